@@ -16,15 +16,26 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/ns1/pulsar-routemap/internal/auth"
 	"github.com/ns1/pulsar-routemap/internal/config"
 	"github.com/ns1/pulsar-routemap/internal/crud"
+	"github.com/ns1/pulsar-routemap/internal/dnssim"
+	"github.com/ns1/pulsar-routemap/internal/fakeserver"
+	"github.com/ns1/pulsar-routemap/internal/generate"
+	"github.com/ns1/pulsar-routemap/internal/keystore"
+	"github.com/ns1/pulsar-routemap/internal/serve"
+	"github.com/ns1/pulsar-routemap/internal/simulate"
+	"github.com/ns1/pulsar-routemap/internal/transform"
 	"github.com/ns1/pulsar-routemap/internal/validate"
 	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/ns1/pulsar-routemap/pkg/model"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"go.uber.org/multierr"
 )
 
@@ -51,6 +62,31 @@ func setupVerbosity(g *config.CommandLineGlobals) error {
 	return nil
 }
 
+func setupLogging(g *config.CommandLineGlobals) error {
+	var w io.Writer = os.Stderr
+
+	if len(g.LogFile) > 0 {
+		f, err := os.OpenFile(g.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("opening log file: %v", err)
+		}
+
+		// Left open until the process exits.
+		w = f
+	}
+
+	switch g.LogFormat {
+	case "text":
+		lg.SetLogger(lg.NewTextLogger(w))
+	case "json":
+		lg.SetLogger(lg.NewJSONLogger(w))
+	default:
+		return fmt.Errorf("invalid log format '%s'; must be text or json", g.LogFormat)
+	}
+
+	return nil
+}
+
 func setupCacheDir(g *config.CommandLineGlobals) error {
 	if err := os.MkdirAll(g.CacheDir, os.ModePerm); err != nil {
 		return fmt.Errorf("creating cachedir: %v", err)
@@ -60,16 +96,25 @@ func setupCacheDir(g *config.CommandLineGlobals) error {
 }
 
 func setupAPIKey(g *config.CommandLineGlobals) error {
-	if len(g.NS1APIKey) == 0 {
+	if len(g.NS1APIKey) > 0 && len(g.APIKeyFile) > 0 {
+		return fmt.Errorf("--api-key and --api-key-file cannot be used together")
+	}
+
+	if len(g.NS1APIKey) == 0 && len(g.APIKeyFile) == 0 {
 		g.NS1APIKey = os.Getenv("NS1_APIKEY")
 	}
 
+	// API keys saved with "auth login" are kept alongside the config file.
+	if len(g.ConfigFile) > 0 {
+		g.KeyStore = keystore.New(filepath.Join(filepath.Dir(g.ConfigFile), "credentials.json"))
+	}
+
 	return nil
 }
 
-func main() {
-	globals := config.NewCommandLineGlobals()
-
+// newRootCommand creates the routemap command and all of its subcommands,
+// storing global options in globals.
+func newRootCommand(globals *config.CommandLineGlobals) *cobra.Command {
 	var rootCmd = cobra.Command{}
 
 	rootCmd.Use = filepath.Base(os.Args[0])
@@ -78,9 +123,11 @@ func main() {
 
 	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		return multierr.Combine(
-			setupVerbosity(&globals),
-			setupAPIKey(&globals),
-			setupCacheDir(&globals))
+			setupVerbosity(globals),
+			setupLogging(globals),
+			setupAPIKey(globals),
+			setupProfile(globals, cmd.Flags()),
+			setupCacheDir(globals))
 	}
 
 	{
@@ -100,25 +147,140 @@ func main() {
 	pf.CountVarP(&globals.Verbosity, "verbose", "v",
 		"Increase the verbosity of output messages. Repeatable up to 3 times.")
 
+	pf.StringVar(&globals.LogFormat, "log-format", "text",
+		"Format of log messages: text, or json for one object per line with time, level, "+
+			"message and fields such as mapid and sha1.")
+
+	pf.StringVar(&globals.LogFile, "log-file", "",
+		"Append log messages to this file instead of writing them to STDERR.")
+
 	pf.StringVar(&globals.CacheDir, "cachedir", globals.CacheDir,
-		"Where to store cached data.")
-	pf.MarkHidden("cachedir") // Not being used yet.
+		"Where to store cached data, such as the record of route maps uploaded from this host.")
+
+	pf.StringVar(&globals.ConfigFile, "config", globals.ConfigFile,
+		"Configuration file containing named profiles.")
+
+	pf.StringVar(&globals.Profile, "profile", "",
+		"Profile from the configuration file to use. May also be set with the NS1_PROFILE "+
+			"environment variable. Options given on the command line or in the environment "+
+			"take precedence over the profile.")
 
 	pf.StringVar(&globals.NS1APIBaseURL, "api-baseurl", globals.NS1APIBaseURL,
 		"Base URL for NS1 REST API. Normally the default will suffice.")
-	pf.MarkHidden("api-baseurl")
 
 	pf.StringVar(&globals.NS1APIKey, "api-key", "",
 		"NS1 API key for commands that require it. You may specify either this option or "+
 			"use the NS1_APIKEY environment variable. The value of this command line option "+
 			"takes precedence over the environment setting.")
 
-	validate.AddCommands(&rootCmd, &globals)
-	crud.AddCommands(&rootCmd, &globals)
+	pf.StringVar(&globals.APIKeyFile, "api-key-file", "",
+		"File containing the NS1 API key. Cannot be used with --api-key. Takes precedence "+
+			"over the NS1_APIKEY environment setting. Keeps the key out of your shell history "+
+			"and process list.")
+
+	pf.DurationVar(&globals.Timeout, "timeout", 0,
+		"Time limit for each API request, e.g. 5m. Default is no limit.")
+
+	pf.StringVar(&globals.ProxyURL, "proxy", "",
+		"HTTP(S) proxy for API requests, e.g. http://proxy.example.com:3128. Default uses the "+
+			"HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.")
+
+	pf.StringVar(&globals.CABundle, "ca-bundle", "",
+		"PEM file of extra certificate authorities to trust, e.g. for an intercepting proxy.")
+
+	pf.StringVar(&globals.ClientCert, "client-cert", "",
+		"PEM file with a certificate for TLS client authentication.")
+
+	pf.StringVar(&globals.ClientKey, "client-key", "",
+		"PEM file with the private key for --client-cert, if not in the same file.")
+
+	pf.StringVar(&globals.MinTLSVersion, "tls-min-version", "",
+		"Minimum TLS version to accept: 1.0, 1.1, 1.2 or 1.3.")
+
+	pf.IntVar(&globals.Limits.MaxSegments, "max-segments", 0,
+		fmt.Sprintf("Fail validation of maps with more segments than this. The default limit for "+
+			"customers is %d. Default is not to check.", model.DefaultMaxSegments))
+
+	pf.IntVar(&globals.Limits.MaxSizeBytes, "max-size-bytes", 0,
+		fmt.Sprintf("Fail validation of maps larger than this many bytes. The default limit for "+
+			"customers is %d. Default is not to check.", model.DefaultMaxSizeBytes))
+
+	pf.StringVar(&globals.FallbackPolicy, "fallback-policy", "",
+		"YAML file mapping primary labels to the fallback labels that must end their map "+
+			"segments. Segments that do not end with them fail validation.")
+
+	pf.StringVar(&globals.LabelsFile, "labels-file", "",
+		"File listing the labels maps may use, one per line. Maps with other labels fail "+
+			"validation, with a suggestion for likely typos.")
+
+	pf.BoolVar(&globals.CheckLabelCase, "check-label-case", false,
+		"Fail validation of maps that write a label with different case in different segments, "+
+			"e.g. SYD and syd.")
+
+	pf.BoolVar(&globals.DryRun, "dry-run", false,
+		"Do all local work (loading, validation, planning) for commands that change route maps, "+
+			"but only print the API requests that would be made.")
+
+	validate.AddCommands(&rootCmd, globals)
+	crud.AddCommands(&rootCmd, globals)
+	auth.AddCommands(&rootCmd, globals)
+	serve.AddCommands(&rootCmd, globals)
+	dnssim.AddCommands(&rootCmd, globals)
+	simulate.AddCommands(&rootCmd, globals)
+	generate.AddCommands(&rootCmd, globals)
+	transform.AddCommands(&rootCmd, globals)
+	fakeserver.AddCommands(&rootCmd, globals)
 
 	rootCmd.SilenceUsage = true
 	rootCmd.SilenceErrors = true
 
+	return &rootCmd
+}
+
+// setupProfile applies the selected profile from the configuration file, if
+// any. Options set on the command line or in the environment take precedence.
+func setupProfile(g *config.CommandLineGlobals, flags *pflag.FlagSet) error {
+	if len(g.Profile) == 0 {
+		g.Profile = os.Getenv("NS1_PROFILE")
+	}
+
+	if len(g.ConfigFile) == 0 {
+		return nil
+	}
+
+	f, err := config.LoadFile(g.ConfigFile)
+	if os.IsNotExist(err) {
+		if len(g.Profile) > 0 {
+			return fmt.Errorf("profile '%s' requested but config file %s does not exist", g.Profile, g.ConfigFile)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if len(g.Profile) == 0 {
+		g.Profile = f.DefaultProfile
+	}
+
+	if len(g.Profile) == 0 {
+		return nil
+	}
+
+	p, err := f.Profile(g.Profile)
+	if err != nil {
+		return fmt.Errorf("%s: %v", g.ConfigFile, err)
+	}
+
+	lg.Debugf("using profile '%s' from %s", g.Profile, g.ConfigFile)
+	g.ApplyProfile(p, flags.Changed)
+
+	return nil
+}
+
+func main() {
+	globals := config.NewCommandLineGlobals()
+	rootCmd := newRootCommand(&globals)
+
 	if err := rootCmd.Execute(); err != nil {
 		lg.Errorf("%v", err)
 		os.Exit(1)
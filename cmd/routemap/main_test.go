@@ -0,0 +1,642 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ns1/pulsar-routemap/internal/config"
+	"github.com/ns1/pulsar-routemap/pkg/api/apitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sydMap = `{"meta":{"version":1},"map":[{"networks":["10.0.0.0/24","2001:db8::/48"],"labels":["syd","mel"]}]}`
+	hkgMap = `{"meta":{"version":1},"map":[{"networks":["10.1.0.0/24"],"labels":["hkg"]}]}`
+	badMap = `{"meta":{"version":1},"map":[{"networks":["10.1.0.1/24"],"labels":[""]}]}`
+)
+
+// testEnv runs routemap commands against a fake API.
+type testEnv struct {
+	t        *testing.T
+	srv      *apitest.Server
+	baseURL  string
+	cacheDir string
+	dir      string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	srv := apitest.NewServer("test-key")
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	dir, err := ioutil.TempDir("", "routemap-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	// Keep the user's environment, configuration and keyring out of the way.
+	// Saved API keys go to an encrypted file next to the config file.
+	t.Setenv("NS1_APIKEY", "")
+	t.Setenv("NS1_PROFILE", "")
+	t.Setenv("NS1_KEYSTORE", "file")
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+
+	return &testEnv{
+		t:        t,
+		srv:      srv,
+		baseURL:  ts.URL + "/v1",
+		cacheDir: filepath.Join(dir, "cache"),
+		dir:      dir,
+	}
+}
+
+// writeFile creates a file relative to the test directory and returns its path.
+func (e *testEnv) writeFile(name string, content string) string {
+	path := filepath.Join(e.dir, name)
+	require.NoError(e.t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+	require.NoError(e.t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+// run executes routemap with args and returns what it wrote to STDOUT.
+func (e *testEnv) run(args ...string) (string, error) {
+	globals := config.NewCommandLineGlobals()
+	cmd := newRootCommand(&globals)
+	cmd.SetArgs(append([]string{
+		"--api-key", "test-key",
+		"--api-baseurl", e.baseURL,
+		"--cachedir", e.cacheDir,
+		"--config", filepath.Join(e.dir, "config.yaml"),
+	}, args...))
+
+	return captureStdout(e.t, cmd.Execute)
+}
+
+// runBare executes routemap with only the given args.
+func (e *testEnv) runBare(args ...string) (string, error) {
+	globals := config.NewCommandLineGlobals()
+	cmd := newRootCommand(&globals)
+	cmd.SetArgs(append([]string{"--cachedir", e.cacheDir}, args...))
+
+	return captureStdout(e.t, cmd.Execute)
+}
+
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	out := make(chan string)
+	go func() {
+		buf := &bytes.Buffer{}
+		io.Copy(buf, r)
+		out <- buf.String()
+	}()
+
+	err = f()
+	w.Close()
+	return <-out, err
+}
+
+func (e *testEnv) mutatingRequests() []string {
+	var reqs []string
+	for _, r := range e.srv.Requests() {
+		if !strings.HasPrefix(r, "GET /pulsar/routemaps") || strings.Contains(r, "/create") || strings.Contains(r, "/replace") {
+			reqs = append(reqs, r)
+		}
+	}
+
+	return reqs
+}
+
+func Test_validateCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	out, err := e.run("validate", "--file", e.writeFile("syd.json", sydMap))
+	assert.NoError(t, err)
+	assert.Contains(t, out, "input map is valid")
+	assert.Contains(t, out, "total networks: 2")
+
+	out, err = e.run("validate", "--file", e.writeFile("bad.json", badMap))
+	assert.EqualError(t, err, "found 2 errors")
+	assert.Contains(t, out, "not properly masked")
+}
+
+func Test_listCommand(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.AddRoutemap("syd", "ready", []byte(sydMap))
+	e.srv.AddRoutemap("hkg", "failed", []byte(hkgMap))
+
+	out, err := e.run("list")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "syd")
+	assert.Contains(t, out, "hkg")
+
+	out, err = e.run("list", "--output", "json", "--filter", "status=ready")
+	assert.NoError(t, err)
+	var rmaps []apitest.Routemap
+	require.NoError(t, json.Unmarshal([]byte(out), &rmaps))
+	require.Len(t, rmaps, 1)
+	assert.Equal(t, "syd", rmaps[0].Name)
+
+	out, err = e.run("list", "--output", "json", "--filter", "status=pending")
+	assert.NoError(t, err)
+	assert.Equal(t, "[]\n", out)
+
+	out, err = e.run("list", "--output", "csv", "--columns", "id,name", "--sort", "name")
+	assert.NoError(t, err)
+	assert.Equal(t, "id,name\n2,hkg\n1,syd\n", out)
+
+	out, err = e.run("list", "--raw")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "["))
+
+	_, err = e.run("list", "--output", "xml")
+	assert.Error(t, err)
+}
+
+func Test_createCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	_, err := e.run("create", "--name", "syd", "--file", e.writeFile("syd.json", sydMap))
+	require.NoError(t, err)
+
+	rmaps := e.srv.Routemaps()
+	require.Len(t, rmaps, 1)
+	assert.Equal(t, "syd", rmaps[0].Name)
+
+	content, _ := e.srv.Content(rmaps[0].MapID)
+	assert.Equal(t, sydMap, string(content))
+
+	_, err = e.run("create", "--name", "bad", "--file", e.writeFile("bad.json", badMap))
+	assert.Error(t, err)
+	assert.Len(t, e.srv.Routemaps(), 1)
+
+	_, err = e.run("create", "--name", "bad", "--no-validate", "--file", e.writeFile("bad.json", badMap))
+	assert.NoError(t, err)
+	assert.Len(t, e.srv.Routemaps(), 2)
+}
+
+func Test_replaceCommand(t *testing.T) {
+	e := newTestEnv(t)
+	syd := e.writeFile("syd.json", sydMap)
+
+	_, err := e.run("create", "--name", "syd", "--file", syd)
+	require.NoError(t, err)
+	mapid := e.srv.Routemaps()[0].MapID
+	arg := strconv.Itoa(mapid)
+
+	// Identical content is skipped...
+	before := len(e.srv.Requests())
+	_, err = e.run("replace", "--mapid", arg, "--yes", "--file", syd)
+	assert.NoError(t, err)
+	assert.Len(t, e.srv.Requests(), before)
+
+	// ...unless forced.
+	_, err = e.run("replace", "--mapid", arg, "--yes", "--force", "--file", syd)
+	assert.NoError(t, err)
+	assert.Len(t, e.srv.Requests(), before+2)
+
+	// Replacing with a much smaller map needs --allow-shrink.
+	hkg := e.writeFile("hkg.json", hkgMap)
+	_, err = e.run("replace", "--mapid", arg, "--yes", "--file", hkg)
+	assert.Error(t, err)
+
+	for _, threshold := range []string{"-1", "100.5"} {
+		_, err = e.run("replace", "--mapid", arg, "--yes", "--shrink-threshold", threshold, "--file", hkg)
+		assert.EqualError(t, err, "shrink-threshold must be between 0 and 100, got "+threshold)
+	}
+
+	_, err = e.run("replace", "--mapid", arg, "--yes", "--allow-shrink", "--file", hkg)
+	assert.NoError(t, err)
+	content, _ := e.srv.Content(mapid)
+	assert.Equal(t, hkgMap, string(content))
+
+	_, err = e.run("replace", "--mapid", "99", "--yes", "--file", syd)
+	assert.Error(t, err)
+}
+
+func Test_replaceWithoutUploadRecord(t *testing.T) {
+	e := newTestEnv(t)
+	mapid := e.srv.AddRoutemap("syd", "ready", []byte(sydMap))
+	hkg := e.writeFile("hkg.json", hkgMap)
+	arg := strconv.Itoa(mapid)
+
+	// Nothing to compare with, so the replace is refused...
+	_, err := e.run("replace", "--mapid", arg, "--yes", "--file", hkg)
+	assert.Error(t, err)
+	content, _ := e.srv.Content(mapid)
+	assert.Equal(t, sydMap, string(content))
+
+	// ...unless allowed.
+	_, err = e.run("replace", "--mapid", arg, "--yes", "--allow-shrink", "--file", hkg)
+	assert.NoError(t, err)
+	content, _ = e.srv.Content(mapid)
+	assert.Equal(t, hkgMap, string(content))
+}
+
+func Test_deleteCommand(t *testing.T) {
+	e := newTestEnv(t)
+	mapid := e.srv.AddRoutemap("syd", "ready", []byte(sydMap))
+
+	_, err := e.run("delete", "--mapid", "99", "--yes")
+	assert.Error(t, err)
+
+	_, err = e.run("delete", "--mapid", strconv.Itoa(mapid), "--yes")
+	assert.NoError(t, err)
+	_, ok := e.srv.Content(mapid)
+	assert.False(t, ok)
+	assert.Empty(t, e.srv.Routemaps())
+}
+
+func Test_applyCommand(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.AddRoutemap("stale", "ready", []byte(hkgMap))
+	e.writeFile("maps/syd.json", sydMap)
+	e.writeFile("maps/hkg.json", hkgMap)
+	dir := filepath.Join(e.dir, "maps")
+
+	out, err := e.run("apply", "--dir", dir, "--prune")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Plan: 2 to create, 0 to replace, 1 to delete, 0 unchanged.")
+	assert.Len(t, e.srv.Routemaps(), 1)
+
+	_, err = e.run("apply", "--dir", dir, "--prune", "--auto-approve")
+	assert.NoError(t, err)
+
+	var names []string
+	for _, m := range e.srv.Routemaps() {
+		names = append(names, m.Name)
+	}
+	assert.ElementsMatch(t, []string{"syd", "hkg"}, names)
+
+	out, err = e.run("apply", "--dir", dir)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Plan: 0 to create, 0 to replace, 0 to delete, 2 unchanged.")
+}
+
+func Test_applyWithoutUploadRecord(t *testing.T) {
+	e := newTestEnv(t)
+	mapid := e.srv.AddRoutemap("syd", "ready", []byte(hkgMap))
+	e.writeFile("maps/syd.json", sydMap)
+	dir := filepath.Join(e.dir, "maps")
+
+	_, err := e.run("apply", "--dir", dir, "--auto-approve")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no record of a previous upload from this host to compare with")
+	content, _ := e.srv.Content(mapid)
+	assert.Equal(t, hkgMap, string(content))
+
+	out, err := e.run("apply", "--dir", dir, "--auto-approve", "--allow-shrink")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Plan: 0 to create, 1 to replace, 0 to delete, 0 unchanged.")
+	content, _ = e.srv.Content(mapid)
+	assert.Equal(t, sydMap, string(content))
+}
+
+func Test_dryRun(t *testing.T) {
+	e := newTestEnv(t)
+	mapid := strconv.Itoa(e.srv.AddRoutemap("syd", "ready", []byte(sydMap)))
+	syd := e.writeFile("syd.json", sydMap)
+
+	out, err := e.run("--dry-run", "create", "--name", "new", "--file", syd)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "[dry-run] GET "+e.baseURL+"/pulsar/routemaps/create?name=new")
+
+	out, err = e.run("--dry-run", "replace", "--mapid", mapid, "--allow-shrink", "--file", syd)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "[dry-run] would replace route map "+mapid+" 'syd' (status: ready")
+	assert.Contains(t, out, "[dry-run] GET "+e.baseURL+"/pulsar/routemaps/"+mapid+"/replace")
+
+	out, err = e.run("--dry-run", "delete", "--mapid", mapid)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "[dry-run] would delete route map "+mapid+" 'syd' (status: ready")
+	assert.Contains(t, out, "[dry-run] DELETE "+e.baseURL+"/pulsar/routemaps/"+mapid)
+
+	_, err = e.run("--dry-run", "delete", "--mapid", "999")
+	assert.EqualError(t, err, "route map 999 not found")
+
+	assert.Empty(t, e.mutatingRequests())
+	assert.Len(t, e.srv.Routemaps(), 1)
+}
+
+func Test_apiFailure(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.FailNext(apitest.OpCreate, http.StatusTooManyRequests, "rate limit exceeded")
+
+	_, err := e.run("create", "--name", "syd", "--file", e.writeFile("syd.json", sydMap))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "rate limit exceeded")
+	assert.Empty(t, e.srv.Routemaps())
+}
+
+func Test_requiresAPIKey(t *testing.T) {
+	e := newTestEnv(t)
+
+	_, err := e.runBare("--config", filepath.Join(e.dir, "missing.yaml"), "list")
+	assert.EqualError(t, err, "NS1 API key is required")
+}
+
+func Test_profiles(t *testing.T) {
+	e := newTestEnv(t)
+	e.srv.AddRoutemap("syd", "ready", []byte(sydMap))
+
+	cfg := e.writeFile("config.yaml", `
+default_profile: broken
+profiles:
+  staging:
+    api_key: test-key
+    api_baseurl: `+e.baseURL+`
+    timeout: 30s
+    limits:
+      max_segments: 1
+  broken:
+    api_key: wrong-key
+    api_baseurl: `+e.baseURL+`
+`)
+
+	out, err := e.runBare("--config", cfg, "--profile", "staging", "list")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "syd")
+
+	// The default profile has the wrong key...
+	_, err = e.runBare("--config", cfg, "list")
+	assert.Error(t, err)
+
+	// ...which the command line or environment overrides.
+	_, err = e.runBare("--config", cfg, "--api-key", "test-key", "list")
+	assert.NoError(t, err)
+
+	t.Setenv("NS1_PROFILE", "staging")
+	_, err = e.runBare("--config", cfg, "list")
+	assert.NoError(t, err)
+
+	// Profile limits apply to validation.
+	twoSegments := `{"meta":{"version":1},"map":[` +
+		`{"networks":["10.0.0.0/24"],"labels":["a"]},{"networks":["10.1.0.0/24"],"labels":["b"]}]}`
+	_, err = e.runBare("--config", cfg, "validate", "--file", e.writeFile("two.json", twoSegments))
+	assert.Error(t, err)
+
+	_, err = e.runBare("--config", cfg, "--profile", "missing", "list")
+	assert.Error(t, err)
+}
+
+func Test_apiKeySources(t *testing.T) {
+	e := newTestEnv(t)
+	t.Setenv("NS1_KEYSTORE_PASSPHRASE", "secret")
+
+	keyFile := e.writeFile("key.txt", "test-key\n")
+	cfg := e.writeFile("config.yaml", `
+profiles:
+  fromcmd:
+    api_key_command: echo test-key
+  fromfile:
+    api_key_file: `+keyFile+`
+  badcmd:
+    api_key_command: "false"
+`)
+
+	base := []string{"--config", cfg, "--api-baseurl", e.baseURL}
+	run := func(args ...string) error {
+		_, err := e.runBare(append(base, args...)...)
+		return err
+	}
+
+	assert.NoError(t, run("--api-key-file", keyFile, "list"))
+	assert.EqualError(t, run("--api-key", "test-key", "--api-key-file", keyFile, "list"),
+		"--api-key and --api-key-file cannot be used together")
+	assert.NoError(t, run("--profile", "fromcmd", "list"))
+	assert.NoError(t, run("--profile", "fromfile", "list"))
+	assert.Error(t, run("--profile", "badcmd", "list"))
+
+	// Nothing saved yet.
+	assert.EqualError(t, run("list"), "NS1 API key is required")
+
+	// A key that does not work is not saved.
+	withStdin(t, "wrong-key\n", func() {
+		assert.Error(t, run("auth", "login"))
+	})
+
+	withStdin(t, "test-key\n", func() {
+		assert.NoError(t, run("auth", "login"))
+	})
+	assert.NoError(t, run("list"))
+
+	assert.NoError(t, run("auth", "logout"))
+	assert.Error(t, run("auth", "logout"))
+	assert.EqualError(t, run("list"), "NS1 API key is required")
+}
+
+func Test_simulateCommand(t *testing.T) {
+	e := newTestEnv(t)
+	mapFile := e.writeFile("syd.json", sydMap)
+	answersFile := e.writeFile("answers.yaml", `answers:
+- answer: 192.0.2.1
+  label: mel
+- answer: 192.0.2.2
+  label: hkg
+- answer: 192.0.2.3
+  label: syd
+`)
+	clientsFile := e.writeFile("clients.txt", "# Sydney\n10.0.0.0/25\n\n192.0.2.0/24\n")
+
+	out, err := e.runBare("simulate", "--map", mapFile, "--answers", answersFile,
+		"--client", "2001:db8::/56", "--clients-file", clientsFile)
+	assert.NoError(t, err)
+
+	lines := strings.Split(out, "\n")
+	assert.Regexp(t, `^2001:db8::/56 +2001:db8::/48 +0 +syd,mel +192.0.2.3 192.0.2.1 +192.0.2.2 \(hkg\)`, lines[2])
+	assert.Regexp(t, `^10.0.0.0/25 +10.0.0.0/24 +0 +syd,mel +192.0.2.3 192.0.2.1 +192.0.2.2 \(hkg\)`, lines[3])
+	assert.Regexp(t, `^192.0.2.0/24 +- +- +- +192.0.2.1 192.0.2.2 192.0.2.3 +-`, lines[4])
+	assert.Contains(t, out, "3 clients: 1 in no map segment, 0 receive no answers.")
+
+	out, err = e.runBare("simulate", "--map", mapFile, "--answers", answersFile,
+		"--client", "192.0.2.1", "--unmatched", "none", "-o", "json")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"client": "192.0.2.1", "found": false, "answers": [], "dropped": [
+		{"answer": "192.0.2.1", "label": "mel"},
+		{"answer": "192.0.2.2", "label": "hkg"},
+		{"answer": "192.0.2.3", "label": "syd"}]}]`, out)
+
+	_, err = e.runBare("simulate", "--map", mapFile, "--answers", answersFile, "--client", "nope")
+	assert.EqualError(t, err, "invalid client subnet 'nope'")
+}
+
+func Test_mergeCommand(t *testing.T) {
+	e := newTestEnv(t)
+	base := e.writeFile("base.json", sydMap)
+	pins := e.writeFile("pins.json",
+		`{"meta":{"version":1},"map":[{"networks":["10.0.0.128/25"],"labels":["hkg"]}]}`)
+	merged := filepath.Join(e.dir, "merged.json")
+
+	out, err := e.runBare("merge", base, pins, "-o", merged)
+	require.NoError(t, err)
+	assert.Contains(t, out, "10.0.0.0/24 ("+base+") partly overridden by 10.0.0.128/25 ("+pins+")\n")
+	assert.Contains(t, out, "merged 2 maps into 2 segments, 3 networks; 1 networks overridden\n")
+
+	data, err := ioutil.ReadFile(merged)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"meta":{"version":1},"map":[
+		{"networks":["10.0.0.0/25","2001:db8::/48"],"labels":["syd","mel"]},
+		{"networks":["10.0.0.128/25"],"labels":["hkg"]}]}`, string(data))
+
+	_, err = e.runBare("merge", base, "-o", merged)
+	assert.EqualError(t, err, "at least two route maps are required")
+}
+
+func Test_splitCommand(t *testing.T) {
+	e := newTestEnv(t)
+	input := e.writeFile("syd.json", sydMap)
+	output := filepath.Join(e.dir, "part.json")
+
+	out, err := e.runBare("split", input, "--by", "family", "-o", output)
+	require.NoError(t, err)
+
+	lines := strings.Split(out, "\n")
+	assert.Regexp(t, `^.*part-ipv4-1.json +1 +1 +1 +0 +\d+ +[0-9a-f]{40}`, lines[2])
+	assert.Regexp(t, `^.*part-ipv6-1.json +1 +1 +0 +1 +\d+ +[0-9a-f]{40}`, lines[3])
+	assert.Contains(t, out, "2 maps, 2 networks.")
+
+	data, err := ioutil.ReadFile(filepath.Join(e.dir, "part-ipv6-1.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"meta":{"version":1},"map":[{"networks":["2001:db8::/48"],"labels":["syd","mel"]}]}`,
+		string(data))
+
+	_, err = e.runBare("split", input, "--max-bytes", "10", "-o", output)
+	assert.EqualError(t, err, "network 10.0.0.0/24 with labels syd,mel does not fit in a map of 10 bytes")
+}
+
+func Test_filterCommand(t *testing.T) {
+	e := newTestEnv(t)
+	input := e.writeFile("syd.json", sydMap)
+
+	out, err := e.runBare("filter", input, "--has-label", "MEL", "--family", "ipv6", "--list")
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::/48\t0\tsyd,mel\n", out)
+
+	out, err = e.runBare("filter", input, "--within", "10.0.0.0/8")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"meta":{"version":1},"map":[{"networks":["10.0.0.0/24"],"labels":["syd","mel"]}]}`, out)
+
+	_, err = e.runBare("filter", input, "--prefix-length", "24-16")
+	assert.EqualError(t, err, "invalid prefix length range '24-16'")
+}
+
+func Test_relabelCommand(t *testing.T) {
+	e := newTestEnv(t)
+	input := e.writeFile("two.json", `{"meta":{"version":1},"map":[
+		{"networks":["10.0.0.0/24"],"labels":["syd","mel"]},
+		{"networks":["10.0.1.0/24"],"labels":["sydney","per","mel"]}]}`)
+	mapping := e.writeFile("renames.csv", "old,new\nper,\n")
+	output := filepath.Join(e.dir, "out.json")
+
+	out, err := e.runBare("relabel", input, "--map", "sydney=syd", "--mapping-file", mapping, "-o", output)
+	require.NoError(t, err)
+	assert.Equal(t, "sydney -> syd (--map): 1 segments\n"+
+		"drop per ("+mapping+":2): 1 segments\n"+
+		"changed 1 segments; removed 0 duplicate labels; merged 1 segments\n", out)
+
+	data, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"meta":{"version":1},"map":[{"networks":["10.0.0.0/24","10.0.1.0/24"],"labels":["syd","mel"]}]}`,
+		string(data))
+
+	_, err = e.runBare("relabel", input, "--map", "syd", "-o", output)
+	assert.EqualError(t, err, "invalid map 'syd' (expected old=new)")
+}
+
+func Test_fallbackPolicy(t *testing.T) {
+	e := newTestEnv(t)
+	input := e.writeFile("syd.json", sydMap)
+	policyFile := e.writeFile("fallbacks.yaml", "fallbacks:\n  syd: [apac, global]\n")
+	output := filepath.Join(e.dir, "out.json")
+
+	out, err := e.runBare("--fallback-policy", policyFile, "validate", "--file", input)
+	assert.EqualError(t, err, "found 1 errors")
+	assert.Contains(t, out, `! labels "syd,mel" do not end with the fallbacks "apac,global" required for primary label "syd"`)
+
+	out, err = e.runBare("--fallback-policy", policyFile, "relabel", input, "--complete-fallbacks", "-o", output)
+	require.NoError(t, err)
+	assert.Contains(t, out, "completed fallbacks of 1 segments")
+
+	_, err = e.runBare("--fallback-policy", policyFile, "validate", "--file", output)
+	assert.NoError(t, err)
+
+	// The policy may also come from the profile.
+	cfg := e.writeFile("config.yaml", "profiles:\n  default:\n    lint:\n      fallback_policy: "+policyFile+"\n")
+	_, err = e.runBare("--config", cfg, "--profile", "default", "validate", "--file", input)
+	assert.EqualError(t, err, "found 1 errors")
+
+	_, err = e.runBare("relabel", input, "--complete-fallbacks", "-o", output)
+	assert.EqualError(t, err, "complete-fallbacks requires a fallback policy")
+}
+
+func Test_labelsFile(t *testing.T) {
+	e := newTestEnv(t)
+	input := e.writeFile("syd.json", `{"meta":{"version":1},"map":[
+		{"networks":["10.0.0.0/24"],"labels":["sydd","mel"]},
+		{"networks":["10.0.1.0/24"],"labels":["MEL"]}]}`)
+	labels := e.writeFile("labels.txt", "syd\nmel\n")
+
+	out, err := e.runBare("--labels-file", labels, "validate", "--file", input)
+	assert.EqualError(t, err, "found 2 errors")
+	assert.Contains(t, out, `! unknown label "sydd"; did you mean "syd"? (in 1 map segments, first at index=0)`)
+	assert.Contains(t, out, `! label "MEL" should be written "mel" (in 1 map segments, first at index=1)`)
+
+	cfg := e.writeFile("config.yaml", "profiles:\n  default:\n    lint:\n      check_label_case: true\n")
+	out, err = e.runBare("--config", cfg, "--profile", "default", "validate", "--file", input)
+	assert.EqualError(t, err, "found 1 errors")
+	assert.Contains(t, out, `! label "MEL" (in 1 map segments, first at index=1) differs in case from "mel" (first at index=0)`)
+
+	// Transformed maps are checked against the same vocabulary.
+	output := filepath.Join(e.dir, "out.json")
+	_, err = e.runBare("--labels-file", labels, "relabel", input, "--map", "MEL=mel", "-o", output)
+	assert.Error(t, err)
+	assert.NoFileExists(t, output)
+
+	_, err = e.runBare("--labels-file", labels, "relabel", input, "--map", "sydd=syd", "--map", "MEL=mel", "-o", output)
+	assert.NoError(t, err)
+}
+
+func withStdin(t *testing.T, input string, f func()) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	go func() {
+		io.WriteString(w, input)
+		w.Close()
+	}()
+
+	orig := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = orig }()
+
+	f()
+}
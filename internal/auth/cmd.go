@@ -0,0 +1,169 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/internal/config"
+	"github.com/ns1/pulsar-routemap/internal/keystore"
+	"github.com/ns1/pulsar-routemap/internal/term"
+	"github.com/ns1/pulsar-routemap/pkg/api"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/spf13/cobra"
+	xterm "golang.org/x/term"
+)
+
+type Options struct {
+	Globals *config.CommandLineGlobals
+
+	SkipVerify bool
+}
+
+func (o *Options) requireKeyStore() error {
+	if o.Globals.KeyStore == nil {
+		return fmt.Errorf("unable to locate the user configuration directory to save API keys in")
+	}
+
+	return nil
+}
+
+func addLoginCommand(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "login",
+		Short: "Save an NS1 API key for the selected profile",
+		Long: "Save an NS1 API key for the selected profile (or \"default\").\n\n" +
+			"The key is read from the terminal without echo, or from STDIN when not running " +
+			"interactively. It is saved in the OS keyring (the Secret Service on Linux) or, " +
+			"when that is unavailable, in a passphrase-encrypted file alongside the config file. " +
+			"Set NS1_KEYSTORE=file to always use the encrypted file.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.requireKeyStore()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunLoginCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.BoolVar(&opts.SkipVerify, "skip-verify", false,
+		"Save the key without checking that it works.")
+
+	parentCmd.AddCommand(sub)
+}
+
+func addLogoutCommand(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "logout",
+		Short: "Remove the saved NS1 API key for the selected profile",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.requireKeyStore()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunLogoutCommand(opts)
+		},
+	}
+
+	parentCmd.AddCommand(sub)
+}
+
+func AddCommands(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	sub := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage saved NS1 API keys",
+	}
+
+	addLoginCommand(sub, globals)
+	addLogoutCommand(sub, globals)
+
+	parentCmd.AddCommand(sub)
+}
+
+func RunLoginCommand(opts *Options) error {
+	profile := opts.Globals.ProfileOrDefault()
+
+	key, err := readAPIKey(profile)
+	if err != nil {
+		return err
+	}
+
+	if !opts.SkipVerify {
+		client, err := api.NewClientWithOptions(opts.Globals.NS1APIBaseURL, key, opts.Globals.ClientOptions())
+		if err != nil {
+			return err
+		}
+
+		if _, err = client.ListRoutemaps(); err != nil {
+			return fmt.Errorf("verifying API key: %v", err)
+		}
+	}
+
+	if err = opts.Globals.KeyStore.Set(profile, key); err != nil {
+		return fmt.Errorf("saving API key: %v", err)
+	}
+
+	lg.Printf("saved API key for profile '%s'", profile)
+	return nil
+}
+
+func RunLogoutCommand(opts *Options) error {
+	profile := opts.Globals.ProfileOrDefault()
+
+	if err := opts.Globals.KeyStore.Delete(profile); err == keystore.ErrNotFound {
+		return fmt.Errorf("no API key is saved for profile '%s'", profile)
+	} else if err != nil {
+		return fmt.Errorf("removing API key: %v", err)
+	}
+
+	lg.Printf("removed API key for profile '%s'", profile)
+	return nil
+}
+
+func readAPIKey(profile string) (string, error) {
+	var (
+		key string
+		err error
+	)
+
+	if term.IsTerminal(os.Stdin) {
+		fmt.Fprintf(os.Stderr, "NS1 API key for profile '%s': ", profile)
+		var b []byte
+		b, err = xterm.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		key = string(b)
+	} else {
+		key, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && len(key) > 0 {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("reading API key: %v", err)
+	}
+
+	key = strings.TrimSpace(key)
+	if len(key) == 0 {
+		return "", fmt.Errorf("API key must not be empty")
+	}
+
+	return key, nil
+}
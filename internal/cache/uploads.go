@@ -0,0 +1,129 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const uploadsFilename = "uploads.json"
+
+// UploadRecord describes the last route map uploaded to a particular mapid.
+type UploadRecord struct {
+	SHA1        string    `json:"sha1"`
+	SizeInBytes int       `json:"size"`
+	Uploaded    time.Time `json:"uploaded"`
+
+	// Measures of the map's content. Address counts are decimal strings since
+	// they may exceed 64 bits. They count overlapping networks once; earlier
+	// versions summed the networks under other keys, which are ignored.
+	NumNetworks int    `json:"networks,omitempty"`
+	IPv4Addrs   string `json:"ipv4AddressesCovered,omitempty"`
+	IPv6Nets64  string `json:"ipv6Slash64sCovered,omitempty"`
+}
+
+// Uploads is a record of the route maps uploaded from this host. Records are
+// keyed by API base URL and then by mapid so that separate NS1 environments
+// do not collide.
+type Uploads struct {
+	path    string
+	Entries map[string]map[string]UploadRecord `json:"entries"`
+}
+
+// LoadUploads reads the upload records from cacheDir. A missing file is not
+// an error; it yields an empty set of records.
+func LoadUploads(cacheDir string) (*Uploads, error) {
+	u := &Uploads{
+		path:    filepath.Join(cacheDir, uploadsFilename),
+		Entries: map[string]map[string]UploadRecord{},
+	}
+
+	data, err := ioutil.ReadFile(u.path)
+	if os.IsNotExist(err) {
+		return u, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading upload cache: %v", err)
+	}
+
+	if err = json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("parsing upload cache %s: %v", u.path, err)
+	}
+
+	if u.Entries == nil {
+		u.Entries = map[string]map[string]UploadRecord{}
+	}
+
+	return u, nil
+}
+
+// Get returns the record of the last upload to mapid, if any.
+func (u *Uploads) Get(baseURL string, mapid int) (UploadRecord, bool) {
+	rec, ok := u.Entries[baseURL][strconv.Itoa(mapid)]
+	return rec, ok
+}
+
+// Put records an upload to mapid, replacing any previous record.
+func (u *Uploads) Put(baseURL string, mapid int, rec UploadRecord) {
+	byID, ok := u.Entries[baseURL]
+	if !ok {
+		byID = map[string]UploadRecord{}
+		u.Entries[baseURL] = byID
+	}
+
+	byID[strconv.Itoa(mapid)] = rec
+}
+
+// Delete forgets any upload to mapid.
+func (u *Uploads) Delete(baseURL string, mapid int) {
+	delete(u.Entries[baseURL], strconv.Itoa(mapid))
+}
+
+// Save writes the records back to the cache directory. The file is replaced
+// atomically so that concurrent readers never see a partial write.
+func (u *Uploads) Save() error {
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(u.path), uploadsFilename+".*")
+	if err != nil {
+		return fmt.Errorf("saving upload cache: %v", err)
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("saving upload cache: %v", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("saving upload cache: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), u.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("saving upload cache: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/internal/keystore"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+)
+
+// DefaultProfileName is used to save an API key with "auth login" when no
+// profile is selected.
+const DefaultProfileName = "default"
+
+// ProfileOrDefault returns the selected profile name, or DefaultProfileName.
+func (g *CommandLineGlobals) ProfileOrDefault() string {
+	if len(g.Profile) == 0 {
+		return DefaultProfileName
+	}
+
+	return g.Profile
+}
+
+// resolveAPIKey finds the API key from the sources that are only consulted
+// when a command needs API access: a key file, a key command and finally
+// the key store.
+func (g *CommandLineGlobals) resolveAPIKey() (string, error) {
+	switch {
+	case len(g.APIKeyFile) > 0:
+		return readAPIKeyFile(g.APIKeyFile)
+	case len(g.APIKeyCommand) > 0:
+		return runAPIKeyCommand(g.APIKeyCommand)
+	case g.KeyStore != nil:
+		key, err := g.KeyStore.Get(g.ProfileOrDefault())
+		if err == keystore.ErrNotFound {
+			return "", nil
+		} else if err != nil {
+			return "", fmt.Errorf("reading saved API key: %v", err)
+		}
+		return key, nil
+	default:
+		return "", nil
+	}
+}
+
+func readAPIKeyFile(filename string) (string, error) {
+	if fi, err := os.Stat(filename); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		lg.Warnf("API key file %s is accessible by other users; consider chmod 600", filename)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("reading API key file: %v", err)
+	}
+
+	key := strings.TrimSpace(string(data))
+	if len(key) == 0 {
+		return "", fmt.Errorf("API key file %s is empty", filename)
+	}
+
+	return key, nil
+}
+
+// runAPIKeyCommand runs command with the system shell and takes the API key
+// from its output.
+func runAPIKeyCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	lg.Debugf("running API key command: %s", command)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running API key command: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	key := strings.TrimSpace(string(out))
+	if len(key) == 0 {
+		return "", fmt.Errorf("API key command produced no output")
+	}
+
+	return key, nil
+}
@@ -18,7 +18,13 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"time"
 
+	"github.com/ns1/pulsar-routemap/internal/keystore"
+	"github.com/ns1/pulsar-routemap/pkg/api"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/policy"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
 	"go.uber.org/multierr"
 )
 
@@ -31,8 +37,52 @@ type CommandLineGlobals struct {
 	// Verbosity is the user's preference for logging output.
 	Verbosity int
 
+	// LogFormat is "text" or "json". LogFile, if set, receives log output
+	// instead of STDERR.
+	LogFormat string
+	LogFile   string
+
+	// DryRun makes mutating commands describe the API requests they would make
+	// rather than making them.
+	DryRun bool
+
+	// ConfigFile is the configuration file holding named profiles.
+	ConfigFile string
+
+	// Profile selects a set of defaults from the configuration file.
+	Profile string
+
 	NS1APIBaseURL string
 	NS1APIKey     string
+
+	// Further sources of the API key when NS1APIKey is not set. They are only
+	// consulted by commands that need API access.
+	APIKeyFile    string
+	APIKeyCommand string
+	KeyStore      keystore.Store
+
+	// Timeout limits each API request. Zero means no timeout.
+	Timeout time.Duration
+
+	// Transport settings for networks with proxies or private CAs.
+	ProxyURL      string
+	CABundle      string
+	ClientCert    string
+	ClientKey     string
+	MinTLSVersion string
+
+	// Limits are checked when validating maps. Zero values are not checked.
+	Limits model.Limits
+
+	// FallbackPolicy, if set, is a policy file of the fallback labels that
+	// map segments must end with. It is checked when validating maps.
+	FallbackPolicy string
+
+	// LabelsFile, if set, lists the labels that maps may use.
+	// CheckLabelCase reports labels written with different case in
+	// different segments. Both are checked when validating maps.
+	LabelsFile     string
+	CheckLabelCase bool
 }
 
 // NewCommandLineGlobals creates a new globals with some defaults.
@@ -46,16 +96,60 @@ func NewCommandLineGlobals() CommandLineGlobals {
 
 	g.CacheDir = path.Join(g.CacheDir, "pulsar-routemap")
 
+	g.ConfigFile = DefaultConfigFile()
+
 	g.NS1APIBaseURL = "https://api.nsone.net/v1"
 
 	return g
 }
 
+// ClientOptions returns the options for creating an API client.
+func (g *CommandLineGlobals) ClientOptions() api.ClientOptions {
+	return api.ClientOptions{
+		Timeout:       g.Timeout,
+		ProxyURL:      g.ProxyURL,
+		CABundle:      g.CABundle,
+		ClientCert:    g.ClientCert,
+		ClientKey:     g.ClientKey,
+		MinTLSVersion: g.MinTLSVersion,
+	}
+}
+
+// ValidatorOptions returns the options for validating maps, loading the
+// fallback policy and labels file if there are any.
+func (g *CommandLineGlobals) ValidatorOptions() (validator.Options, error) {
+	opts := validator.Options{Limits: g.Limits, CheckLabelCase: g.CheckLabelCase}
+
+	if len(g.FallbackPolicy) > 0 {
+		p, err := policy.LoadFile(g.FallbackPolicy)
+		if err != nil {
+			return opts, err
+		}
+		opts.Policy = p
+	}
+
+	if len(g.LabelsFile) > 0 {
+		v, err := policy.LoadVocabularyFile(g.LabelsFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.Vocabulary = v
+	}
+
+	return opts, nil
+}
+
 // RequireAPIAccess validates that global parameters are set appropriately
 // for REST API access.
 func (g *CommandLineGlobals) RequireAPIAccess() error {
 	var err error
 
+	if len(g.NS1APIKey) == 0 {
+		if g.NS1APIKey, err = g.resolveAPIKey(); err != nil {
+			return err
+		}
+	}
+
 	if len(g.NS1APIKey) == 0 {
 		multierr.AppendInto(&err, fmt.Errorf("NS1 API key is required"))
 	}
@@ -0,0 +1,153 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Profile is a named set of defaults for the global options, e.g. for one NS1
+// account.
+type Profile struct {
+	APIKey        string        `yaml:"api_key"`
+	APIKeyFile    string        `yaml:"api_key_file"`
+	APIKeyCommand string        `yaml:"api_key_command"`
+	APIBaseURL    string        `yaml:"api_baseurl"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Limits        model.Limits  `yaml:"limits"`
+	Lint          Lint          `yaml:"lint"`
+
+	ProxyURL      string `yaml:"proxy"`
+	CABundle      string `yaml:"ca_bundle"`
+	ClientCert    string `yaml:"client_cert"`
+	ClientKey     string `yaml:"client_key"`
+	MinTLSVersion string `yaml:"tls_min_version"`
+}
+
+// Lint holds checks made when validating maps beyond those Pulsar requires.
+type Lint struct {
+	FallbackPolicy string `yaml:"fallback_policy"`
+	LabelsFile     string `yaml:"labels_file"`
+	CheckLabelCase bool   `yaml:"check_label_case"`
+}
+
+// File is the contents of the configuration file.
+type File struct {
+	// DefaultProfile is used when no profile is selected on the command line
+	// or in the environment.
+	DefaultProfile string             `yaml:"default_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// DefaultConfigFile returns the path of the configuration file in the user's
+// configuration directory, e.g. ~/.config/pulsar-routemap/config.yaml.
+func DefaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return path.Join(dir, "pulsar-routemap", "config.yaml")
+}
+
+// LoadFile reads and parses a configuration file. Unknown settings are an
+// error so that typos do not go unnoticed.
+func LoadFile(filename string) (*File, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{}
+	if err = yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %v", filename, err)
+	}
+
+	return f, nil
+}
+
+// Profile returns the named profile.
+func (f *File) Profile(name string) (Profile, error) {
+	if p, ok := f.Profiles[name]; ok {
+		return p, nil
+	}
+
+	var names []string
+	for n := range f.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return Profile{}, fmt.Errorf("profile '%s' not found (available: %s)", name, strings.Join(names, ", "))
+}
+
+// ApplyProfile sets global options from the profile. Options given on the
+// command line (isSet returns true for the flag name) or already set from the
+// environment are left alone.
+func (g *CommandLineGlobals) ApplyProfile(p Profile, isSet func(flag string) bool) {
+	if len(g.NS1APIKey) == 0 && len(g.APIKeyFile) == 0 {
+		switch {
+		case len(p.APIKey) > 0:
+			g.NS1APIKey = p.APIKey
+		case len(p.APIKeyFile) > 0:
+			g.APIKeyFile = p.APIKeyFile
+		case len(p.APIKeyCommand) > 0:
+			g.APIKeyCommand = p.APIKeyCommand
+		}
+	}
+
+	if len(p.APIBaseURL) > 0 && !isSet("api-baseurl") {
+		g.NS1APIBaseURL = p.APIBaseURL
+	}
+
+	if p.Timeout > 0 && !isSet("timeout") {
+		g.Timeout = p.Timeout
+	}
+
+	setString := func(dst *string, value string, flag string) {
+		if len(value) > 0 && !isSet(flag) {
+			*dst = value
+		}
+	}
+
+	setString(&g.ProxyURL, p.ProxyURL, "proxy")
+	setString(&g.CABundle, p.CABundle, "ca-bundle")
+	setString(&g.ClientCert, p.ClientCert, "client-cert")
+	setString(&g.ClientKey, p.ClientKey, "client-key")
+	setString(&g.MinTLSVersion, p.MinTLSVersion, "tls-min-version")
+	setString(&g.FallbackPolicy, p.Lint.FallbackPolicy, "fallback-policy")
+	setString(&g.LabelsFile, p.Lint.LabelsFile, "labels-file")
+
+	if p.Lint.CheckLabelCase && !isSet("check-label-case") {
+		g.CheckLabelCase = true
+	}
+
+	if p.Limits.MaxSegments > 0 && !isSet("max-segments") {
+		g.Limits.MaxSegments = p.Limits.MaxSegments
+	}
+
+	if p.Limits.MaxSizeBytes > 0 && !isSet("max-size-bytes") {
+		g.Limits.MaxSizeBytes = p.Limits.MaxSizeBytes
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crud
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/internal/cache"
+	"github.com/ns1/pulsar-routemap/pkg/api"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+)
+
+// Plan actions, in the order they are executed.
+const (
+	actionCreate    = "create"
+	actionReplace   = "replace"
+	actionDelete    = "delete"
+	actionUnchanged = "unchanged"
+)
+
+// planStep is a single change needed to bring the remote route maps in line
+// with the local directory.
+type planStep struct {
+	action   string
+	name     string
+	mapID    int
+	filename string
+	reason   string
+	sha1     string
+}
+
+// localMapFile is a route map file found in the apply directory. The map
+// name is the filename without its extension(s).
+type localMapFile struct {
+	name     string
+	filename string
+}
+
+func (o *Options) validateDir() error {
+	if len(o.Dir) == 0 {
+		return fmt.Errorf("dir parameter is required")
+	}
+
+	if fi, err := os.Stat(o.Dir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", o.Dir)
+	}
+
+	return nil
+}
+
+func RunApplyCommand(opts *Options) error {
+	client, err := newClient(opts.Globals)
+	if err != nil {
+		return err
+	}
+
+	uploads, err := cache.LoadUploads(opts.Globals.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	plan, err := computePlan(opts, client, uploads)
+	if err != nil {
+		return err
+	}
+
+	printPlan(os.Stdout, plan)
+
+	if !planHasChanges(plan) {
+		return nil
+	}
+
+	if opts.Globals.DryRun {
+		fmt.Println()
+		return executePlan(opts, client, uploads, plan)
+	} else if !opts.AutoApprove {
+		fmt.Println("\nRe-run with --auto-approve to apply these changes.")
+		return nil
+	}
+
+	return executePlan(opts, client, uploads, plan)
+}
+
+func findLocalMaps(dir string) ([]localMapFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		files []localMapFile
+		seen  = map[string]string{}
+	)
+
+	for _, e := range entries {
+		// Compressed files such as <name>.json.gz are also accepted.
+		base := model.TrimCompressedExtension(e.Name())
+		if e.IsDir() || !strings.HasSuffix(base, ".json") {
+			continue
+		}
+
+		name := strings.TrimSuffix(base, ".json")
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("both %s and %s define route map '%s'", other, e.Name(), name)
+		}
+		seen[name] = e.Name()
+
+		files = append(files, localMapFile{
+			name:     name,
+			filename: filepath.Join(dir, e.Name()),
+		})
+	}
+
+	return files, nil
+}
+
+func computePlan(opts *Options, client api.Client, uploads *cache.Uploads) ([]planStep, error) {
+	locals, err := findLocalMaps(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	remotes, err := fetchRoutemaps(client)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteByName := map[string][]api.RoutemapPayload{}
+	for _, r := range remotes {
+		remoteByName[r.Name] = append(remoteByName[r.Name], r)
+	}
+
+	var (
+		plan       []planStep
+		localNames = map[string]bool{}
+	)
+
+	for _, l := range locals {
+		localNames[l.name] = true
+
+		matches := remoteByName[l.name]
+		if len(matches) > 1 {
+			return nil, fmt.Errorf("%d remote route maps are named '%s'; unable to decide which to update",
+				len(matches), l.name)
+		}
+
+		lg.Infof("loading route map '%s' from %s", l.name, l.filename)
+		root, err := loadForUpload(opts, l.filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", l.filename, err)
+		}
+
+		// Only the hash is needed to plan; the map is loaded again when the
+		// plan is applied so that all of them are not held in memory at once.
+		step := planStep{name: l.name, filename: l.filename, sha1: hex.EncodeToString(root.SHA1)}
+		root.ClearRaw()
+
+		if len(matches) == 0 {
+			step.action = actionCreate
+			step.reason = "not found remotely"
+		} else {
+			step.mapID = matches[0].MapID
+
+			rec, ok := uploads.Get(opts.Globals.NS1APIBaseURL, step.mapID)
+			if (!ok || rec.SHA1 != step.sha1) && !opts.AllowShrink {
+				if err = checkShrink(rec, root, opts.ShrinkThreshold); err != nil {
+					return nil, fmt.Errorf("%s: map may be much smaller than route map %d "+
+						"(%v); use --allow-shrink to replace it anyway", l.filename, step.mapID, err)
+				}
+			}
+
+			switch {
+			case !ok:
+				step.action = actionReplace
+				step.reason = "no record of a previous upload"
+			case rec.SHA1 != step.sha1:
+				step.action = actionReplace
+				step.reason = fmt.Sprintf("content changed (sha1 %s -> %s)", rec.SHA1, step.sha1)
+			case opts.Force:
+				step.action = actionReplace
+				step.reason = "unchanged, but --force was given"
+			default:
+				step.action = actionUnchanged
+			}
+		}
+
+		plan = append(plan, step)
+	}
+
+	if opts.Prune {
+		for _, r := range remotes {
+			if !localNames[r.Name] {
+				plan = append(plan, planStep{
+					action: actionDelete,
+					name:   r.Name,
+					mapID:  r.MapID,
+					reason: "not present in " + opts.Dir,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(plan, func(i, j int) bool {
+		return actionOrder(plan[i].action) < actionOrder(plan[j].action)
+	})
+
+	return plan, nil
+}
+
+func actionOrder(action string) int {
+	switch action {
+	case actionCreate:
+		return 0
+	case actionReplace:
+		return 1
+	case actionDelete:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func planHasChanges(plan []planStep) bool {
+	for _, s := range plan {
+		if s.action != actionUnchanged {
+			return true
+		}
+	}
+
+	return false
+}
+
+func printPlan(w io.Writer, plan []planStep) {
+	counts := map[string]int{}
+
+	for _, s := range plan {
+		counts[s.action]++
+
+		switch s.action {
+		case actionCreate:
+			fmt.Fprintf(w, "+ create    %s (from %s)\n", s.name, s.filename)
+		case actionReplace:
+			fmt.Fprintf(w, "~ replace   %s [mapid %d] (from %s): %s\n", s.name, s.mapID, s.filename, s.reason)
+		case actionDelete:
+			fmt.Fprintf(w, "- delete    %s [mapid %d]: %s\n", s.name, s.mapID, s.reason)
+		default:
+			fmt.Fprintf(w, "= unchanged %s [mapid %d]\n", s.name, s.mapID)
+		}
+	}
+
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to replace, %d to delete, %d unchanged.\n",
+		counts[actionCreate], counts[actionReplace], counts[actionDelete], counts[actionUnchanged])
+}
+
+func executePlan(opts *Options, client api.Client, uploads *cache.Uploads, plan []planStep) error {
+	baseURL := opts.Globals.NS1APIBaseURL
+	created := map[string]cache.UploadRecord{}
+
+	for _, s := range plan {
+		var (
+			root *model.RoutemapRoot
+			err  error
+		)
+
+		switch s.action {
+		case actionCreate:
+			lg.Printf("creating route map '%s'", s.name)
+			if root, err = loadPlanned(s); err == nil {
+				if err = client.CreateRoutemap(root, s.name); err == nil {
+					created[s.name] = newUploadRecord(root)
+				}
+			}
+		case actionReplace:
+			lg.With(lg.F("mapid", s.mapID)).Printf("replacing route map '%s' [mapid %d]", s.name, s.mapID)
+			if root, err = loadPlanned(s); err == nil {
+				if err = client.ReplaceRoutemap(root, s.mapID); err == nil {
+					recordUpload(uploads, baseURL, s.mapID, root)
+				}
+			}
+		case actionDelete:
+			lg.With(lg.F("mapid", s.mapID)).Printf("deleting route map '%s' [mapid %d]", s.name, s.mapID)
+			if err = client.DeleteRoutemap(s.mapID); err == nil {
+				uploads.Delete(baseURL, s.mapID)
+			}
+		}
+
+		if err != nil {
+			if !opts.Globals.DryRun {
+				saveUploads(uploads)
+			}
+			return fmt.Errorf("applying %s of '%s': %v", s.action, s.name, err)
+		}
+	}
+
+	if opts.Globals.DryRun {
+		return nil
+	}
+
+	// The create API does not return the new mapid, so look them up in order to
+	// record what was uploaded.
+	if len(created) > 0 {
+		if remotes, err := fetchRoutemaps(client); err != nil {
+			lg.Warnf("unable to record uploads of new route maps: %v", err)
+		} else {
+			for _, r := range remotes {
+				if rec, ok := created[r.Name]; ok {
+					uploads.Put(baseURL, r.MapID, rec)
+				}
+			}
+		}
+	}
+
+	saveUploads(uploads)
+	return nil
+}
+
+// loadPlanned loads the map to upload for step s, which was validated when
+// the plan was computed. It fails if the file has changed since then.
+func loadPlanned(s planStep) (*model.RoutemapRoot, error) {
+	root, err := model.LoadRoutemapFileOrStdin(s.filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s.filename, err)
+	}
+
+	if sha1 := hex.EncodeToString(root.SHA1); sha1 != s.sha1 {
+		return nil, fmt.Errorf("%s has changed since the plan was computed (sha1 %s -> %s)",
+			s.filename, s.sha1, sha1)
+	}
+
+	return root, nil
+}
+
+// saveUploads persists the upload cache. Failing to do so only costs a
+// redundant upload next time, so it is not fatal.
+func saveUploads(uploads *cache.Uploads) {
+	if err := uploads.Save(); err != nil {
+		lg.Warnf("%v", err)
+	}
+}
@@ -16,8 +16,11 @@ package crud
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/ns1/pulsar-routemap/internal/config"
+	"github.com/ns1/pulsar-routemap/pkg/api"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"go.uber.org/multierr"
@@ -30,9 +33,36 @@ type Options struct {
 
 	InputFilename string
 	SkipValidate  bool
+	Force         bool
 	MapID         int
 	Name          string
 	RawOutput     bool // for list command only.
+
+	// Safeguards for commands that change existing route maps.
+	AssumeYes       bool
+	AllowShrink     bool
+	ShrinkThreshold float64
+
+	// Output shaping for the list command only.
+	OutputFormat string
+	Filters      []string
+	SortBy       string
+	Columns      []string
+
+	// Declarative sync for the apply command only.
+	Dir         string
+	Prune       bool
+	AutoApprove bool
+}
+
+// newClient creates an API client from the global options. In dry-run mode
+// the client only describes requests that would change route maps.
+func newClient(g *config.CommandLineGlobals) (api.Client, error) {
+	if g.DryRun {
+		return api.NewDryRunClient(g.NS1APIBaseURL, g.NS1APIKey, g.ClientOptions(), os.Stdout)
+	}
+
+	return api.NewClientWithOptions(g.NS1APIBaseURL, g.NS1APIKey, g.ClientOptions())
 }
 
 func (o *Options) validateName() error {
@@ -51,9 +81,17 @@ func (o *Options) validateMapID() error {
 	return nil
 }
 
+func (o *Options) validateShrinkThreshold() error {
+	if o.ShrinkThreshold < 0 || o.ShrinkThreshold > 100 {
+		return fmt.Errorf("shrink-threshold must be between 0 and 100, got %g", o.ShrinkThreshold)
+	}
+
+	return nil
+}
+
 func (o *Options) addFileFlag(flags *pflag.FlagSet) {
 	flags.StringVar(&o.InputFilename, "file", "",
-		"Route map file to validate. Default is STDIN.")
+		"Route map file to validate. Default is STDIN. May be compressed with gzip, bzip2 or zstd.")
 }
 
 func (o *Options) addNoValidateFlag(flags *pflag.FlagSet) {
@@ -61,6 +99,25 @@ func (o *Options) addNoValidateFlag(flags *pflag.FlagSet) {
 		"Do not validate the route map before uploading.")
 }
 
+func (o *Options) addForceFlag(flags *pflag.FlagSet) {
+	flags.BoolVar(&o.Force, "force", false,
+		"Upload even when the map is identical to the last upload made from this host.")
+}
+
+func (o *Options) addYesFlag(flags *pflag.FlagSet) {
+	flags.BoolVarP(&o.AssumeYes, "yes", "y", false,
+		"Do not ask for confirmation. Required when not running interactively.")
+}
+
+func (o *Options) addShrinkFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&o.AllowShrink, "allow-shrink", false,
+		"Replace the map even if it is much smaller than the previous upload, or if there is "+
+			"no record of a previous upload from this host to compare with.")
+	flags.Float64Var(&o.ShrinkThreshold, "shrink-threshold", 10,
+		"Percentage by which the number of networks or address coverage may shrink compared "+
+			"to the previous upload made from this host before a replace is refused.")
+}
+
 func (o *Options) addMapIDFlag(flags *pflag.FlagSet, desc string) {
 	flags.IntVar(&o.MapID, "mapid", -1, desc)
 }
@@ -99,7 +156,10 @@ func addListCommand(parentCmd *cobra.Command, globals *config.CommandLineGlobals
 		Aliases: []string{"ls"},
 		Short:   "List available route maps",
 		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return opts.Globals.RequireAPIAccess()
+			return multierr.Combine(
+				opts.Globals.RequireAPIAccess(),
+				opts.validateListOptions(),
+			)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return RunListCommand(opts)
@@ -108,7 +168,22 @@ func addListCommand(parentCmd *cobra.Command, globals *config.CommandLineGlobals
 
 	flags := sub.Flags()
 
-	flags.BoolVar(&opts.RawOutput, "raw", false, "Output in JSON. Default is pretty-printed output.")
+	flags.BoolVar(&opts.RawOutput, "raw", false,
+		"Output the unmodified JSON response from the API. Overrides all other output options.")
+
+	flags.StringVarP(&opts.OutputFormat, "output", "o", OutputTable,
+		fmt.Sprintf("Output format. One of: %s.", strings.Join(outputFormats, ", ")))
+
+	flags.StringArrayVar(&opts.Filters, "filter", nil,
+		"Only show route maps where field=value (case-insensitive), e.g. status=ready. "+
+			"Repeatable; all filters must match.")
+
+	flags.StringVar(&opts.SortBy, "sort", "",
+		"Sort by the named field, e.g. modified. Prefix with '-' to sort in descending order.")
+
+	flags.StringSliceVar(&opts.Columns, "columns", nil,
+		fmt.Sprintf("Comma-separated columns for table, wide and csv output. Available: %s.",
+			strings.Join(listColumnNames(), ", ")))
 
 	parentCmd.AddCommand(sub)
 }
@@ -122,6 +197,7 @@ func addReplaceCommand(parentCmd *cobra.Command, globals *config.CommandLineGlob
 			return multierr.Combine(
 				opts.Globals.RequireAPIAccess(),
 				opts.validateMapID(),
+				opts.validateShrinkThreshold(),
 			)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -133,6 +209,9 @@ func addReplaceCommand(parentCmd *cobra.Command, globals *config.CommandLineGlob
 
 	opts.addFileFlag(flags)
 	opts.addNoValidateFlag(flags)
+	opts.addForceFlag(flags)
+	opts.addYesFlag(flags)
+	opts.addShrinkFlags(flags)
 	opts.addMapIDFlag(flags, "Replace an existing map identified by this ID.")
 
 	parentCmd.AddCommand(sub)
@@ -156,14 +235,56 @@ func addDeleteCommand(parentCmd *cobra.Command, globals *config.CommandLineGloba
 
 	flags := sub.Flags()
 
+	opts.addYesFlag(flags)
 	opts.addMapIDFlag(flags, "Delete an existing map identified by this ID.")
 
 	parentCmd.AddCommand(sub)
 }
 
+func addApplyCommand(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "apply",
+		Short: "Sync remote route maps with a directory of map files",
+		Long: "Sync remote route maps with a directory of map files.\n\n" +
+			"Each file named <name>.json in the directory is the desired content of the " +
+			"remote route map called <name>. Missing maps are created and maps whose content " +
+			"differs from the last upload made from this host are replaced. The plan is " +
+			"printed and only carried out when --auto-approve is given.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return multierr.Combine(
+				opts.Globals.RequireAPIAccess(),
+				opts.validateDir(),
+				opts.validateShrinkThreshold(),
+			)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunApplyCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	opts.addNoValidateFlag(flags)
+	opts.addForceFlag(flags)
+	opts.addShrinkFlags(flags)
+
+	flags.StringVar(&opts.Dir, "dir", "",
+		"Directory of route map files named <name>.json.")
+
+	flags.BoolVar(&opts.Prune, "prune", false,
+		"Delete remote route maps that have no corresponding file in the directory.")
+
+	flags.BoolVar(&opts.AutoApprove, "auto-approve", false,
+		"Carry out the plan. Without this only the plan is printed.")
+
+	parentCmd.AddCommand(sub)
+}
+
 func AddCommands(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
 	addCreateCommand(parentCmd, globals)
 	addReplaceCommand(parentCmd, globals)
 	addListCommand(parentCmd, globals)
 	addDeleteCommand(parentCmd, globals)
+	addApplyCommand(parentCmd, globals)
 }
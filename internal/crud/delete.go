@@ -15,17 +15,23 @@
 package crud
 
 import (
-	"github.com/ns1/pulsar-routemap/internal/api"
 	"github.com/ns1/pulsar-routemap/pkg/lg"
 )
 
 func RunDeleteCommand(opts *Options) error {
-	client := api.NewClient(opts.Globals.NS1APIBaseURL, opts.Globals.NS1APIKey)
+	client, err := newClient(opts.Globals)
+	if err != nil {
+		return err
+	}
+
+	if err := confirmChange(opts, client, actionDelete); err != nil {
+		return err
+	}
 
 	if err := client.DeleteRoutemap(opts.MapID); err != nil {
 		return err
-	} else {
-		lg.Printf("deleted route map %d", opts.MapID)
+	} else if !opts.Globals.DryRun {
+		lg.With(lg.F("mapid", opts.MapID)).Printf("deleted route map %d", opts.MapID)
 	}
 
 	return nil
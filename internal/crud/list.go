@@ -15,49 +15,319 @@
 package crud
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
 
-	"github.com/ns1/pulsar-routemap/internal/api"
+	"github.com/ns1/pulsar-routemap/pkg/api"
+	"gopkg.in/yaml.v2"
 )
 
-func RunListCommand(opts *Options) error {
-	client := api.NewClient(opts.Globals.NS1APIBaseURL, opts.Globals.NS1APIKey)
+// Output formats supported by the list command.
+const (
+	OutputTable = "table"
+	OutputWide  = "wide"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+	OutputCSV   = "csv"
+)
+
+var outputFormats = []string{OutputTable, OutputJSON, OutputYAML, OutputCSV, OutputWide}
+
+// listColumn is a single column of the list command's tabular output.
+type listColumn struct {
+	name    string
+	numeric bool
+	value   func(m *api.RoutemapPayload) string
+}
+
+var listColumns = []listColumn{
+	{name: "id", numeric: true, value: func(m *api.RoutemapPayload) string { return strconv.Itoa(m.MapID) }},
+	{name: "name", value: func(m *api.RoutemapPayload) string { return m.Name }},
+	{name: "created", numeric: true, value: func(m *api.RoutemapPayload) string { return m.CreatedString() }},
+	{name: "modified", numeric: true, value: func(m *api.RoutemapPayload) string { return m.ModifiedString() }},
+	{name: "status", value: func(m *api.RoutemapPayload) string { return m.Status }},
+	{name: "customer", numeric: true, value: func(m *api.RoutemapPayload) string { return strconv.Itoa(m.Customer) }},
+	{name: "error", value: func(m *api.RoutemapPayload) string { return m.ErrorCode }},
+}
+
+// defaultColumns are shown by the table and csv formats; the wide format shows
+// every column.
+var defaultColumns = []string{"id", "name", "created", "modified", "status"}
+
+func findListColumn(name string) (listColumn, bool) {
+	for _, c := range listColumns {
+		if c.name == strings.ToLower(name) {
+			return c, true
+		}
+	}
+
+	return listColumn{}, false
+}
 
-	if body, err := client.ListRoutemaps(); err != nil {
+func listColumnNames() []string {
+	names := make([]string, 0, len(listColumns))
+	for _, c := range listColumns {
+		names = append(names, c.name)
+	}
+
+	return names
+}
+
+// sortKey returns a value for ordering by column c. Numeric columns sort by
+// their underlying value rather than their formatted string.
+func sortKey(c listColumn, m *api.RoutemapPayload) int64 {
+	switch c.name {
+	case "id":
+		return int64(m.MapID)
+	case "created":
+		return m.Created
+	case "modified":
+		return m.Modified
+	case "customer":
+		return int64(m.Customer)
+	default:
+		return 0
+	}
+}
+
+func (o *Options) validateListOptions() error {
+	if o.RawOutput {
+		return nil
+	}
+
+	valid := false
+	for _, f := range outputFormats {
+		if o.OutputFormat == f {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("unsupported output format '%s' (expected one of: %s)",
+			o.OutputFormat, strings.Join(outputFormats, ", "))
+	}
+
+	for _, f := range o.Filters {
+		if k, _, err := parseFilter(f); err != nil {
+			return err
+		} else if _, ok := findListColumn(k); !ok {
+			return fmt.Errorf("unknown filter field '%s' (expected one of: %s)",
+				k, strings.Join(listColumnNames(), ", "))
+		}
+	}
+
+	if len(o.SortBy) > 0 {
+		if _, ok := findListColumn(strings.TrimPrefix(o.SortBy, "-")); !ok {
+			return fmt.Errorf("unknown sort field '%s' (expected one of: %s)",
+				o.SortBy, strings.Join(listColumnNames(), ", "))
+		}
+	}
+
+	for _, name := range o.Columns {
+		if _, ok := findListColumn(name); !ok {
+			return fmt.Errorf("unknown column '%s' (expected one of: %s)",
+				name, strings.Join(listColumnNames(), ", "))
+		}
+	}
+
+	return nil
+}
+
+func parseFilter(filter string) (string, string, error) {
+	parts := strings.SplitN(filter, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return "", "", fmt.Errorf("invalid filter '%s' (expected field=value)", filter)
+	}
+
+	return strings.ToLower(parts[0]), parts[1], nil
+}
+
+func RunListCommand(opts *Options) error {
+	client, err := newClient(opts.Globals)
+	if err != nil {
 		return err
-	} else if opts.RawOutput {
+	}
+
+	if opts.RawOutput {
+		body, err := client.ListRoutemaps()
+		if err != nil {
+			return err
+		}
+
 		fmt.Printf("%s\n", body)
 		return nil
-	} else {
-		return prettyPrint(body)
 	}
+
+	rmaps, err := fetchRoutemaps(client)
+	if err != nil {
+		return err
+	}
+
+	return printRoutemaps(os.Stdout, rmaps, opts)
+}
+
+// fetchRoutemaps lists the customer's route maps and parses the response.
+func fetchRoutemaps(client api.Client) ([]api.RoutemapPayload, error) {
+	body, err := client.ListRoutemaps()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRoutemaps(body)
 }
 
-func prettyPrint(body []byte) error {
+func parseRoutemaps(body []byte) ([]api.RoutemapPayload, error) {
 	var rmaps []api.RoutemapPayload
 	if err := json.Unmarshal(body, &rmaps); err != nil {
-		return fmt.Errorf("parsing route map payload: %v", err)
+		return nil, fmt.Errorf("parsing route map payload: %v", err)
+	}
+
+	return rmaps, nil
+}
+
+func printRoutemaps(w io.Writer, rmaps []api.RoutemapPayload, opts *Options) error {
+	rmaps = filterRoutemaps(rmaps, opts.Filters)
+	sortRoutemaps(rmaps, opts.SortBy)
+
+	switch opts.OutputFormat {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rmaps)
+	case OutputYAML:
+		return yaml.NewEncoder(w).Encode(rmaps)
+	case OutputCSV:
+		return printCSV(w, rmaps, selectColumns(opts.Columns, defaultColumns))
+	case OutputWide:
+		return printTable(w, rmaps, selectColumns(opts.Columns, listColumnNames()))
+	default:
+		return printTable(w, rmaps, selectColumns(opts.Columns, defaultColumns))
 	}
+}
 
-	tw := tabwriter.NewWriter(os.Stdout, 8, 8, 1, ' ', 0)
-	defer tw.Flush()
+func filterRoutemaps(rmaps []api.RoutemapPayload, filters []string) []api.RoutemapPayload {
+	if len(filters) == 0 {
+		return rmaps
+	}
+
+	// Not nil, so that no matches are output as an empty JSON array.
+	filtered := []api.RoutemapPayload{}
+
+	for i := range rmaps {
+		matches := true
+		for _, f := range filters {
+			// Filters have already been checked by validateListOptions.
+			k, v, _ := parseFilter(f)
+			c, _ := findListColumn(k)
+			if !strings.EqualFold(c.value(&rmaps[i]), v) {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			filtered = append(filtered, rmaps[i])
+		}
+	}
+
+	return filtered
+}
+
+// sortRoutemaps orders the route maps by the named column. A leading "-"
+// reverses the order.
+func sortRoutemaps(rmaps []api.RoutemapPayload, sortBy string) {
+	if len(sortBy) == 0 {
+		return
+	}
+
+	desc := strings.HasPrefix(sortBy, "-")
+	c, _ := findListColumn(strings.TrimPrefix(sortBy, "-"))
+
+	sort.SliceStable(rmaps, func(i, j int) bool {
+		a, b := &rmaps[i], &rmaps[j]
+		if desc {
+			a, b = b, a
+		}
+
+		if c.numeric {
+			return sortKey(c, a) < sortKey(c, b)
+		}
+		return c.value(a) < c.value(b)
+	})
+}
+
+func selectColumns(names []string, defaults []string) []listColumn {
+	if len(names) == 0 {
+		names = defaults
+	}
+
+	cols := make([]listColumn, 0, len(names))
+	for _, n := range names {
+		if c, ok := findListColumn(n); ok {
+			cols = append(cols, c)
+		}
+	}
+
+	return cols
+}
+
+func printTable(w io.Writer, rmaps []api.RoutemapPayload, cols []listColumn) error {
+	tw := tabwriter.NewWriter(w, 8, 8, 1, ' ', 0)
 
 	pp := func(values ...string) {
 		line := strings.Join(values, "\t")
 		fmt.Fprintf(tw, "%s\t\n", line)
 	}
 
-	pp("id", "name", "created", "modified", "status")
-	pp("--", "----", "-------", "--------", "------")
+	var header, underline []string
+	for _, c := range cols {
+		header = append(header, c.name)
+		underline = append(underline, strings.Repeat("-", len(c.name)))
+	}
 
-	for _, m := range rmaps {
-		pp(strconv.Itoa(m.MapID), m.Name, m.CreatedString(), m.ModifiedString(), m.Status)
+	pp(header...)
+	pp(underline...)
+
+	for i := range rmaps {
+		var values []string
+		for _, c := range cols {
+			values = append(values, c.value(&rmaps[i]))
+		}
+		pp(values...)
 	}
 
-	return nil
+	return tw.Flush()
+}
+
+func printCSV(w io.Writer, rmaps []api.RoutemapPayload, cols []listColumn) error {
+	cw := csv.NewWriter(w)
+
+	var header []string
+	for _, c := range cols {
+		header = append(header, c.name)
+	}
+
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for i := range rmaps {
+		var values []string
+		for _, c := range cols {
+			values = append(values, c.value(&rmaps[i]))
+		}
+
+		if err := cw.Write(values); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
 }
@@ -0,0 +1,154 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crud
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+
+	"github.com/ns1/pulsar-routemap/internal/cache"
+	"github.com/ns1/pulsar-routemap/internal/term"
+	"github.com/ns1/pulsar-routemap/pkg/api"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"go.uber.org/multierr"
+)
+
+// mapStats are the measures of a route map compared by the replace safeguard.
+type mapStats struct {
+	NumNetworks int
+	model.Coverage
+}
+
+// computeMapStats measures the networks of root. Unparsable networks are
+// ignored; they are reported by validation.
+func computeMapStats(root *model.RoutemapRoot) mapStats {
+	stats := mapStats{Coverage: model.NewCoverage()}
+
+	for _, m := range root.Routemap {
+		for _, n := range m.Networks {
+			_, ipnet, err := net.ParseCIDR(n)
+			if err != nil {
+				continue
+			}
+
+			stats.NumNetworks++
+			stats.Add(ipnet)
+		}
+	}
+
+	stats.Finish()
+	return stats
+}
+
+// checkShrink compares root with the last upload recorded for the map and
+// returns an error if it is smaller by more than thresholdPct percent in
+// number of networks or address coverage. Without a record to compare with
+// it cannot tell, so it also returns an error.
+func checkShrink(prev cache.UploadRecord, root *model.RoutemapRoot, thresholdPct float64) error {
+	if prev.NumNetworks == 0 {
+		// No previous upload from this host, or one recorded before these
+		// measures were kept.
+		return fmt.Errorf("no record of a previous upload from this host to compare with")
+	}
+
+	cur := computeMapStats(root)
+
+	var err error
+
+	if shrunk, pct := shrinkage(big.NewInt(int64(prev.NumNetworks)), big.NewInt(int64(cur.NumNetworks)), thresholdPct); shrunk {
+		multierr.AppendInto(&err, fmt.Errorf("number of networks shrinks by %.1f%% (%d -> %d)",
+			pct, prev.NumNetworks, cur.NumNetworks))
+	}
+
+	if prevV4, ok := new(big.Int).SetString(prev.IPv4Addrs, 10); ok {
+		if shrunk, pct := shrinkage(prevV4, cur.IPv4Addrs, thresholdPct); shrunk {
+			multierr.AppendInto(&err, fmt.Errorf("IPv4 coverage shrinks by %.1f%% (%s -> %s addresses)",
+				pct, prevV4, cur.IPv4Addrs))
+		}
+	}
+
+	if prevV6, ok := new(big.Int).SetString(prev.IPv6Nets64, 10); ok {
+		if shrunk, pct := shrinkage(prevV6, cur.IPv6Nets64, thresholdPct); shrunk {
+			multierr.AppendInto(&err, fmt.Errorf("IPv6 coverage shrinks by %.1f%% (%s -> %s /64 networks)",
+				pct, prevV6, cur.IPv6Nets64))
+		}
+	}
+
+	return err
+}
+
+// shrinkage returns whether cur is smaller than prev by more than thresholdPct
+// percent, and by how much.
+func shrinkage(prev *big.Int, cur *big.Int, thresholdPct float64) (bool, float64) {
+	if prev.Sign() == 0 || cur.Cmp(prev) >= 0 {
+		return false, 0
+	}
+
+	diff := new(big.Float).SetInt(new(big.Int).Sub(prev, cur))
+	ratio, _ := new(big.Float).Quo(diff, new(big.Float).SetInt(prev)).Float64()
+	pct := ratio * 100
+
+	return pct > thresholdPct, pct
+}
+
+// confirmChange asks the user to confirm an action on an existing route map
+// unless they already have with --yes. The map's name and status are looked
+// up so the user can see what they are about to change; with --dry-run they
+// are only printed.
+func confirmChange(opts *Options, client api.Client, action string) error {
+	if opts.AssumeYes && !opts.Globals.DryRun {
+		return nil
+	}
+
+	remotes, err := fetchRoutemaps(client)
+	if err != nil {
+		return err
+	}
+
+	var target *api.RoutemapPayload
+	for i := range remotes {
+		if remotes[i].MapID == opts.MapID {
+			target = &remotes[i]
+		}
+	}
+
+	if target == nil {
+		return fmt.Errorf("route map %d not found", opts.MapID)
+	}
+
+	if opts.Globals.DryRun {
+		fmt.Printf("[dry-run] would %s route map %d '%s' (status: %s, modified: %s)\n",
+			action, target.MapID, target.Name, target.Status, target.ModifiedString())
+		return nil
+	}
+
+	if !term.IsTerminal(os.Stdin) || (action == actionReplace && len(opts.InputFilename) == 0) {
+		return fmt.Errorf("unable to ask for confirmation to %s route map %d '%s'; use --yes to proceed",
+			action, target.MapID, target.Name)
+	}
+
+	ok, err := term.Confirm(os.Stdin, os.Stderr,
+		fmt.Sprintf("About to %s route map %d '%s' (status: %s, modified: %s). Continue?",
+			action, target.MapID, target.Name, target.Status, target.ModifiedString()))
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("%s cancelled", action)
+	}
+
+	return nil
+}
@@ -16,15 +16,126 @@ package crud
 
 import (
 	"encoding/hex"
+	"fmt"
+	"time"
 
-	"github.com/ns1/pulsar-routemap/internal/api"
+	"github.com/ns1/pulsar-routemap/internal/cache"
 	"github.com/ns1/pulsar-routemap/internal/validate"
+	"github.com/ns1/pulsar-routemap/pkg/api"
 	"github.com/ns1/pulsar-routemap/pkg/lg"
 	"github.com/ns1/pulsar-routemap/pkg/model"
 	"github.com/ns1/pulsar-routemap/pkg/validator"
 )
 
 func RunCreateOrReplaceCommand(opts *Options) error {
+	root, err := loadForUpload(opts, opts.InputFilename)
+	if err != nil {
+		return err
+	}
+
+	log := lg.With(lg.F("sha1", hex.EncodeToString(root.SHA1)), lg.F("size", root.SizeInBytes))
+	log.Infof("uploading route map: meta version = %d", root.MetaVersion())
+
+	baseURL := opts.Globals.NS1APIBaseURL
+	client, err := newClient(opts.Globals)
+	if err != nil {
+		return err
+	}
+
+	uploads, err := cache.LoadUploads(opts.Globals.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	if opts.MapID > 0 {
+		log = log.With(lg.F("mapid", opts.MapID))
+
+		rec, ok := uploads.Get(baseURL, opts.MapID)
+		if ok && rec.SHA1 == hex.EncodeToString(root.SHA1) {
+			if !opts.Force {
+				log.Printf("route map %d is unchanged since the last upload at %s; skipping (use --force to upload anyway)",
+					opts.MapID, rec.Uploaded.Format(time.RFC3339))
+				return nil
+			}
+
+			log.Infof("route map %d is unchanged since the last upload; uploading anyway", opts.MapID)
+		}
+
+		if !opts.AllowShrink {
+			if err = checkShrink(rec, root, opts.ShrinkThreshold); err != nil {
+				errSummary := validate.PrettyPrintErrors(err)
+				log.Errorf("map may be much smaller than route map %d; use --allow-shrink to replace it anyway",
+					opts.MapID)
+				return errSummary
+			}
+		}
+
+		if err = confirmChange(opts, client, actionReplace); err != nil {
+			return err
+		}
+
+		log.Infof("replacing existing mapid = %d", opts.MapID)
+		if err = client.ReplaceRoutemap(root, opts.MapID); err != nil {
+			return err
+		}
+
+		if opts.Globals.DryRun {
+			return nil
+		}
+
+		recordUpload(uploads, baseURL, opts.MapID, root)
+	} else {
+		log.Infof("creating new map: %s", opts.Name)
+		if err = client.CreateRoutemap(root, opts.Name); err != nil {
+			return err
+		}
+
+		if opts.Globals.DryRun {
+			return nil
+		}
+
+		// The create API does not return the new mapid, so look it up by name.
+		if mapid, err := lookupMapID(client, opts.Name); err != nil {
+			lg.Warnf("unable to record upload of new route map: %v", err)
+			return nil
+		} else {
+			log.With(lg.F("mapid", mapid)).Printf("created route map %d", mapid)
+			recordUpload(uploads, baseURL, mapid, root)
+		}
+	}
+
+	saveUploads(uploads)
+	return nil
+}
+
+// lookupMapID finds the mapid of the only route map called name.
+func lookupMapID(client api.Client, name string) (int, error) {
+	remotes, err := fetchRoutemaps(client)
+	if err != nil {
+		return 0, err
+	}
+
+	mapid := 0
+	for _, r := range remotes {
+		if r.Name != name {
+			continue
+		} else if mapid > 0 {
+			return 0, fmt.Errorf("more than one route map is named '%s'", name)
+		}
+
+		mapid = r.MapID
+	}
+
+	if mapid == 0 {
+		return 0, fmt.Errorf("no route map is named '%s'", name)
+	}
+
+	return mapid, nil
+}
+
+// loadForUpload loads a route map from the named file (or STDIN), validating
+// it unless the user opted out.
+func loadForUpload(opts *Options, filename string) (*model.RoutemapRoot, error) {
 	var (
 		root *model.RoutemapRoot
 		err  error
@@ -32,31 +143,42 @@ func RunCreateOrReplaceCommand(opts *Options) error {
 
 	if opts.SkipValidate {
 		lg.Infof("skipping validation on upload")
-		if root, err = model.LoadRoutemapFileOrStdin(opts.InputFilename); err != nil {
-			return err
+		if root, err = model.LoadRoutemapFileOrStdin(filename); err != nil {
+			return nil, err
 		}
 	} else {
-		if root, _, err = validator.LoadAndValidate(opts.InputFilename); err != nil {
+		var validatorOpts validator.Options
+		if validatorOpts, err = opts.Globals.ValidatorOptions(); err != nil {
+			return nil, err
+		}
+
+		if root, _, err = validator.LoadAndValidateWithOptions(filename, validatorOpts); err != nil {
 			errSummary := validate.PrettyPrintErrors(err)
 			lg.Errorf("map is invalid; halting upload process")
-			return errSummary
+			return nil, errSummary
 		}
 
 		lg.Infof("map is valid; ready for upload")
 	}
 
-	lg.Infof("uploading route map: meta version = %d, sha1 = %s, size = %d",
-		root.MetaVersion(),
-		hex.EncodeToString(root.SHA1),
-		root.SizeInBytes)
+	return root, nil
+}
+
+// recordUpload notes in the upload cache that root is now live as mapid.
+func recordUpload(uploads *cache.Uploads, baseURL string, mapid int, root *model.RoutemapRoot) {
+	uploads.Put(baseURL, mapid, newUploadRecord(root))
+}
 
-	client := api.NewClient(opts.Globals.NS1APIBaseURL, opts.Globals.NS1APIKey)
+// newUploadRecord describes root as uploaded now.
+func newUploadRecord(root *model.RoutemapRoot) cache.UploadRecord {
+	stats := computeMapStats(root)
 
-	if opts.MapID > 0 {
-		lg.Infof("replacing existing mapid = %d", opts.MapID)
-		return client.ReplaceRoutemap(root, opts.MapID)
-	} else {
-		lg.Infof("creating new map: %s", opts.Name)
-		return client.CreateRoutemap(root, opts.Name)
+	return cache.UploadRecord{
+		SHA1:        hex.EncodeToString(root.SHA1),
+		SizeInBytes: root.SizeInBytes,
+		Uploaded:    time.Now().UTC(),
+		NumNetworks: stats.NumNetworks,
+		IPv4Addrs:   stats.IPv4Addrs.String(),
+		IPv6Nets64:  stats.IPv6Nets64.String(),
 	}
 }
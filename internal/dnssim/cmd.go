@@ -0,0 +1,132 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnssim
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+	"github.com/ns1/pulsar-routemap/internal/config"
+	"github.com/ns1/pulsar-routemap/pkg/answers"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/ns1/pulsar-routemap/pkg/lookup"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+)
+
+type Options struct {
+	Globals *config.CommandLineGlobals
+
+	MapFilename     string
+	AnswersFilename string
+	Listen          string
+	Unmatched       string
+	TTL             uint32
+}
+
+func (o *Options) validate() error {
+	var allErrs error
+
+	if len(o.MapFilename) == 0 {
+		multierr.AppendInto(&allErrs, fmt.Errorf("map parameter is required"))
+	}
+	if len(o.AnswersFilename) == 0 {
+		multierr.AppendInto(&allErrs, fmt.Errorf("answers parameter is required"))
+	}
+
+	_, err := answers.ParseUnmatched(o.Unmatched)
+	multierr.AppendInto(&allErrs, err)
+
+	return allErrs
+}
+
+func AddCommands(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "dns-sim",
+		Short: "Serve DNS answers filtered and ordered by a route map",
+		Long: "Serve DNS answers filtered and ordered by a route map.\n\n" +
+			"A and AAAA queries for any name are answered from the answers file. The " +
+			"client is the address in the EDNS Client Subnet option, or the source " +
+			"address of the query. Answers whose label is in the client's map segment " +
+			"are returned in the order of the segment's labels; the others are dropped.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validate()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunDNSSimCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.StringVar(&opts.MapFilename, "map", "",
+		"Route map file. May be compressed with gzip, bzip2 or zstd.")
+
+	flags.StringVar(&opts.AnswersFilename, "answers", "",
+		"YAML or JSON file listing the answers and their labels.")
+
+	flags.StringVar(&opts.Listen, "listen", "127.0.0.1:5353",
+		"Address to listen on, for both UDP and TCP.")
+
+	flags.StringVar(&opts.Unmatched, "unmatched", string(answers.UnmatchedAll),
+		"Answers for clients outside of every map segment: all, or none.")
+
+	flags.Uint32Var(&opts.TTL, "ttl", 60,
+		"TTL of the answers, in seconds.")
+
+	parentCmd.AddCommand(sub)
+}
+
+func RunDNSSimCommand(opts *Options) error {
+	validatorOpts, err := opts.Globals.ValidatorOptions()
+	if err != nil {
+		return err
+	}
+
+	root, _, err := validator.LoadAndValidateWithOptions(opts.MapFilename, validatorOpts)
+	if err != nil {
+		return fmt.Errorf("route map %s is invalid: %v", opts.MapFilename, err)
+	}
+
+	table, err := lookup.New(root)
+	if err != nil {
+		return err
+	}
+
+	all, err := answers.LoadFile(opts.AnswersFilename)
+	if err != nil {
+		return err
+	}
+
+	unmatched, _ := answers.ParseUnmatched(opts.Unmatched)
+
+	handler, err := NewHandler(table, all, unmatched, opts.TTL)
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, 2)
+	for _, network := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: opts.Listen, Net: network, Handler: handler}
+		go func() {
+			errs <- srv.ListenAndServe()
+		}()
+	}
+
+	lg.Printf("simulated DNS server listening on %s (udp and tcp)", opts.Listen)
+	return <-errs
+}
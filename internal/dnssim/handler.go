@@ -0,0 +1,155 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnssim
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/ns1/pulsar-routemap/pkg/answers"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/ns1/pulsar-routemap/pkg/lookup"
+)
+
+// Handler answers A and AAAA queries for any name the way Pulsar would for a
+// record whose answers are filtered and ordered by a route map.
+type Handler struct {
+	table     *lookup.Table
+	answers   []answers.Answer
+	unmatched answers.Unmatched
+	ttl       uint32
+}
+
+// NewHandler creates a Handler. Every answer must be an IP address.
+func NewHandler(table *lookup.Table, all []answers.Answer, unmatched answers.Unmatched, ttl uint32) (*Handler, error) {
+	for _, a := range all {
+		if net.ParseIP(a.Answer) == nil {
+			return nil, fmt.Errorf("answer '%s' (label '%s') is not an IP address", a.Answer, a.Label)
+		}
+	}
+
+	return &Handler{table: table, answers: all, unmatched: unmatched, ttl: ttl}, nil
+}
+
+// clientSubnet returns the EDNS Client Subnet option of the request, if any.
+func clientSubnet(req *dns.Msg) *dns.EDNS0_SUBNET {
+	if opt := req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+				return ecs
+			}
+		}
+	}
+
+	return nil
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	default:
+		return nil
+	}
+}
+
+func (h *Handler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	if len(req.Question) != 1 {
+		resp.SetRcode(req, dns.RcodeFormatError)
+		w.WriteMsg(resp)
+		return
+	}
+
+	q := req.Question[0]
+	ecs := clientSubnet(req)
+
+	// A source prefix length of zero means the resolver does not want the
+	// client's address used, so answer for the resolver itself (RFC 7871
+	// section 7.1.2).
+	useECS := ecs != nil && ecs.SourceNetmask > 0
+
+	client := remoteIP(w.RemoteAddr())
+	if useECS {
+		client = ecs.Address
+	}
+
+	var kept, dropped []answers.Answer
+	match, found := h.table.Lookup(client)
+	if found {
+		kept, dropped = answers.Order(h.answers, match.Labels)
+	} else {
+		kept, dropped = h.unmatched.Apply(h.answers)
+	}
+
+	lg.With(lg.F("client", client), lg.F("ecs", useECS), lg.F("network", match.Network),
+		lg.F("segment", match.Segment), lg.F("kept", len(kept)), lg.F("dropped", len(dropped))).
+		Infof("%s %s", dns.TypeToString[q.Qtype], q.Name)
+
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: h.ttl}
+	for _, a := range kept {
+		ip := net.ParseIP(a.Answer)
+		v4 := ip.To4()
+
+		switch {
+		case v4 != nil && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY):
+			hdr.Rrtype = dns.TypeA
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: v4})
+		case v4 == nil && (q.Qtype == dns.TypeAAAA || q.Qtype == dns.TypeANY):
+			hdr.Rrtype = dns.TypeAAAA
+			resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+
+	if opt := req.IsEdns0(); opt != nil {
+		respOpt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+		respOpt.SetUDPSize(opt.UDPSize())
+
+		if ecs != nil {
+			// The scope tells caching resolvers how widely the answer applies:
+			// the matching network, less any more specific networks inside it
+			// that other clients would match. It is no wider than the source
+			// prefix given, as nothing more is known about the client.
+			scope := &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        ecs.Family,
+				SourceNetmask: ecs.SourceNetmask,
+				Address:       ecs.Address,
+			}
+
+			if useECS {
+				if ones := h.table.Scope(client); ones < int(ecs.SourceNetmask) {
+					scope.SourceScope = uint8(ones)
+				} else {
+					scope.SourceScope = ecs.SourceNetmask
+				}
+			}
+
+			respOpt.Option = append(respOpt.Option, scope)
+		}
+
+		resp.Extra = append(resp.Extra, respOpt)
+	}
+
+	if err := w.WriteMsg(resp); err != nil {
+		lg.Warnf("writing DNS response: %v", err)
+	}
+}
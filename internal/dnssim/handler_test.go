@@ -0,0 +1,168 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnssim
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/ns1/pulsar-routemap/pkg/answers"
+	"github.com/ns1/pulsar-routemap/pkg/lookup"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, unmatched answers.Unmatched) string {
+	table, err := lookup.New(&model.RoutemapRoot{
+		Routemap: []model.Routemap{
+			{Networks: []string{"198.51.100.0/24"}, Labels: []string{"sin", "hkg"}},
+			{Networks: []string{"203.0.113.0/24", "2001:db8::/32"}, Labels: []string{"syd"}},
+			{Networks: []string{"0.0.0.0/8"}, Labels: []string{"sin"}},
+			{Networks: []string{"100.64.0.0/16"}, Labels: []string{"syd"}},
+			{Networks: []string{"100.64.1.0/24"}, Labels: []string{"hkg"}},
+		},
+	})
+	require.NoError(t, err)
+
+	handler, err := NewHandler(table, []answers.Answer{
+		{Answer: "192.0.2.1", Label: "hkg"},
+		{Answer: "192.0.2.2", Label: "sin"},
+		{Answer: "192.0.2.3", Label: "syd"},
+		{Answer: "2001:db8:ffff::3", Label: "syd"},
+	}, unmatched, 30)
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: conn, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	<-started
+
+	return conn.LocalAddr().String()
+}
+
+func query(t *testing.T, addr string, qtype uint16, subnet string) *dns.Msg {
+	req := &dns.Msg{}
+	req.SetQuestion("www.example.com.", qtype)
+
+	if len(subnet) > 0 {
+		_, ipnet, err := net.ParseCIDR(subnet)
+		require.NoError(t, err)
+		ones, _ := ipnet.Mask.Size()
+
+		ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: uint8(ones), Address: ipnet.IP}
+		if ipnet.IP.To4() == nil {
+			ecs.Family = 2
+		}
+
+		opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+		opt.SetUDPSize(dns.DefaultMsgSize)
+		opt.Option = append(opt.Option, ecs)
+		req.Extra = append(req.Extra, opt)
+	}
+
+	resp, _, err := (&dns.Client{}).Exchange(req, addr)
+	require.NoError(t, err)
+	return resp
+}
+
+func answerStrings(msg *dns.Msg) []string {
+	var result []string
+	for _, rr := range msg.Answer {
+		switch r := rr.(type) {
+		case *dns.A:
+			result = append(result, r.A.String())
+		case *dns.AAAA:
+			result = append(result, r.AAAA.String())
+		}
+	}
+
+	return result
+}
+
+func Test_Handler(t *testing.T) {
+	addr := startServer(t, answers.UnmatchedAll)
+
+	// Answers follow the segment's label order.
+	resp := query(t, addr, dns.TypeA, "198.51.100.0/24")
+	assert.Equal(t, []string{"192.0.2.2", "192.0.2.1"}, answerStrings(resp))
+	assert.Equal(t, uint32(30), resp.Answer[0].Header().Ttl)
+
+	ecs := resp.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+	assert.Equal(t, uint8(24), ecs.SourceScope)
+
+	resp = query(t, addr, dns.TypeAAAA, "2001:db8:1::/48")
+	assert.Equal(t, []string{"2001:db8:ffff::3"}, answerStrings(resp))
+
+	// No ECS: the source address (127.0.0.1) is in no segment.
+	resp = query(t, addr, dns.TypeA, "")
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, answerStrings(resp))
+	assert.Nil(t, resp.IsEdns0())
+
+	// ECS with a source prefix length of zero: the source address is used
+	// rather than the (zeroed) ECS address.
+	resp = query(t, addr, dns.TypeA, "0.0.0.0/0")
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, answerStrings(resp))
+	ecs = resp.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+	assert.Equal(t, uint8(0), ecs.SourceScope)
+}
+
+func Test_Handler_unmatchedNone(t *testing.T) {
+	addr := startServer(t, answers.UnmatchedNone)
+
+	resp := query(t, addr, dns.TypeA, "192.0.2.0/24")
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Empty(t, resp.Answer)
+
+	// The answer holds for 192.0.0.0/6: 198.51.100.0/24 is in 192.0.0.0/5.
+	ecs := resp.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+	assert.Equal(t, uint8(6), ecs.SourceScope)
+}
+
+func Test_Handler_nestedScope(t *testing.T) {
+	addr := startServer(t, answers.UnmatchedAll)
+
+	tests := []struct {
+		subnet string
+		answer string
+		scope  uint8
+	}{
+		{"100.64.1.0/24", "192.0.2.1", 24},
+		// In the /16, but the answer only holds clear of 100.64.1.0/24.
+		{"100.64.0.0/24", "192.0.2.3", 24},
+		{"100.64.200.0/24", "192.0.2.3", 17},
+		// No wider than the source prefix given.
+		{"100.64.0.0/20", "192.0.2.3", 20},
+	}
+
+	for _, tt := range tests {
+		resp := query(t, addr, dns.TypeA, tt.subnet)
+		assert.Equal(t, tt.answer, answerStrings(resp)[0], tt.subnet)
+
+		ecs := resp.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+		assert.Equal(t, tt.scope, ecs.SourceScope, tt.subnet)
+	}
+}
+
+func Test_NewHandler_invalidAnswer(t *testing.T) {
+	_, err := NewHandler(&lookup.Table{}, []answers.Answer{{Answer: "www.example.com", Label: "a"}},
+		answers.UnmatchedAll, 30)
+	assert.EqualError(t, err, "answer 'www.example.com' (label 'a') is not an IP address")
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakeserver
+
+import (
+	"net/http"
+
+	"github.com/ns1/pulsar-routemap/internal/config"
+	"github.com/ns1/pulsar-routemap/pkg/api/apitest"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/spf13/cobra"
+)
+
+type Options struct {
+	Globals *config.CommandLineGlobals
+
+	Listen      string
+	APIKey      string
+	Transitions []string
+}
+
+func AddCommands(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "fake-server",
+		Short: "Serve an in-memory fake of the NS1 route map API for testing",
+		Long: "Serve an in-memory fake of the NS1 route map API for testing.\n\n" +
+			"Point other commands at it with --api-baseurl http://<listen address>. " +
+			"All state is lost when the server exits.",
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunFakeServerCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.StringVar(&opts.Listen, "listen", "127.0.0.1:8080",
+		"Address to listen on.")
+
+	flags.StringVar(&opts.APIKey, "require-api-key", "",
+		"Reject API requests that do not use this API key. Default accepts any key.")
+
+	flags.StringSliceVar(&opts.Transitions, "statuses", apitest.DefaultTransitions,
+		"Statuses a route map moves through after each upload, one per list request. "+
+			"Use status:code to set an error code, e.g. failed:INVALID_MAP.")
+
+	parentCmd.AddCommand(sub)
+}
+
+func RunFakeServerCommand(opts *Options) error {
+	srv := apitest.NewServer(opts.APIKey)
+	srv.SetTransitions(opts.Transitions...)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lg.Infof("%s %s", r.Method, r.URL)
+		srv.ServeHTTP(w, r)
+	})
+
+	lg.Printf("fake route map API listening on http://%s", opts.Listen)
+	return http.ListenAndServe(opts.Listen, handler)
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generate
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/internal/config"
+	"github.com/ns1/pulsar-routemap/pkg/generate"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/spf13/cobra"
+)
+
+type Options struct {
+	Globals *config.CommandLineGlobals
+
+	OutputFilename string
+	Generate       generate.Options
+}
+
+func AddCommands(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a synthetic route map for load and scale testing",
+		Long: "Generate a synthetic route map for load and scale testing.\n\n" +
+			"The map is valid unless errors are injected: networks are properly masked, " +
+			"within the prefix length limits and do not overlap. The same options and " +
+			"seed always produce the same map.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.Generate.Validate()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunGenerateCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.StringVarP(&opts.OutputFilename, "output", "o", "",
+		"File to write the route map to. Default is STDOUT.")
+
+	flags.IntVar(&opts.Generate.Segments, "segments", 1000,
+		"Number of map segments.")
+
+	flags.IntVar(&opts.Generate.NetworksPerSegment, "networks-per-segment", 10,
+		"Number of networks in each segment.")
+
+	flags.Float64Var(&opts.Generate.V6Ratio, "v6-ratio", 0.2,
+		"Fraction of networks, from 0 to 1, that are IPv6.")
+
+	flags.IntVar(&opts.Generate.Labels, "labels", 20,
+		"Number of distinct labels. Each segment has one to three of them.")
+
+	flags.Int64Var(&opts.Generate.Seed, "seed", 1,
+		"Seed for the random choices. Change it to get a different map of the same shape.")
+
+	flags.StringToIntVar(&opts.Generate.Inject, "inject", nil,
+		fmt.Sprintf("Number of segments with each kind of validation error, e.g. unmasked=3,bad-cidr=1. "+
+			"Kinds: %s.", strings.Join(generate.ErrorKinds, ", ")))
+
+	parentCmd.AddCommand(sub)
+}
+
+func RunGenerateCommand(opts *Options) error {
+	var (
+		stats generate.Stats
+		err   error
+	)
+
+	if len(opts.OutputFilename) == 0 {
+		stats, err = generate.Write(os.Stdout, opts.Generate)
+	} else {
+		stats, err = generateFile(opts.OutputFilename, opts.Generate)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lg.Printf("generated %d segments, %d networks, %d bytes (sha1 %s)",
+		stats.Segments, stats.Networks, stats.SizeInBytes, hex.EncodeToString(stats.SHA1))
+	return nil
+}
+
+// generateFile writes a generated map to filename. A partial map is removed
+// if writing fails.
+func generateFile(filename string, opts generate.Options) (generate.Stats, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return generate.Stats{}, err
+	}
+
+	stats, err := generate.Write(f, opts)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("writing %s: %v", filename, closeErr)
+	}
+
+	if err != nil {
+		os.Remove(filename)
+	}
+
+	return stats, err
+}
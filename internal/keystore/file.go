@@ -0,0 +1,209 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ns1/pulsar-routemap/internal/term"
+	"golang.org/x/crypto/scrypt"
+	xterm "golang.org/x/term"
+)
+
+// scrypt parameters recommended for interactive logins as of 2017.
+const (
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32 // AES-256
+	saltLen      = 16
+)
+
+// sealedKey is an API key encrypted with AES-GCM using a key derived from
+// the user's passphrase.
+type sealedKey struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type fileContents struct {
+	Profiles map[string]sealedKey `json:"profiles"`
+}
+
+// fileStore keeps API keys in a file, each encrypted with a passphrase.
+type fileStore struct {
+	filename   string
+	passphrase func() ([]byte, error)
+}
+
+func (s *fileStore) Get(profile string) (string, error) {
+	contents, err := s.load()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, ok := contents.Profiles[profile]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	pass, err := s.passphrase()
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(pass, sealed.Salt)
+	if err != nil {
+		return "", err
+	}
+
+	plain, err := gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(profile))
+	if err != nil {
+		return "", fmt.Errorf("decrypting API key from %s: wrong passphrase or corrupt file", s.filename)
+	}
+
+	return string(plain), nil
+}
+
+func (s *fileStore) Set(profile string, apiKey string) error {
+	contents, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	pass, err := s.passphrase()
+	if err != nil {
+		return err
+	}
+
+	sealed := sealedKey{Salt: make([]byte, saltLen)}
+	if _, err = io.ReadFull(rand.Reader, sealed.Salt); err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(pass, sealed.Salt)
+	if err != nil {
+		return err
+	}
+
+	sealed.Nonce = make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, sealed.Nonce); err != nil {
+		return err
+	}
+
+	// The profile name is authenticated so that keys can't be swapped between
+	// profiles by editing the file.
+	sealed.Ciphertext = gcm.Seal(nil, sealed.Nonce, []byte(apiKey), []byte(profile))
+	contents.Profiles[profile] = sealed
+
+	return s.save(contents)
+}
+
+func (s *fileStore) Delete(profile string) error {
+	contents, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := contents.Profiles[profile]; !ok {
+		return ErrNotFound
+	}
+
+	delete(contents.Profiles, profile)
+	return s.save(contents)
+}
+
+func (s *fileStore) load() (*fileContents, error) {
+	contents := &fileContents{Profiles: map[string]sealedKey{}}
+
+	data, err := ioutil.ReadFile(s.filename)
+	if os.IsNotExist(err) {
+		return contents, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, contents); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", s.filename, err)
+	}
+
+	if contents.Profiles == nil {
+		contents.Profiles = map[string]sealedKey{}
+	}
+
+	return contents, nil
+}
+
+func (s *fileStore) save(contents *fileContents) error {
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(s.filename), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.filename, data, 0600)
+}
+
+func newGCM(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// passphrase reads the passphrase for the encrypted file from the
+// NS1_KEYSTORE_PASSPHRASE environment variable or, failing that, asks for it
+// on the terminal.
+func passphrase() ([]byte, error) {
+	if p := os.Getenv("NS1_KEYSTORE_PASSPHRASE"); len(p) > 0 {
+		return []byte(p), nil
+	}
+
+	if !term.IsTerminal(os.Stdin) {
+		return nil, fmt.Errorf("a passphrase is needed for the encrypted API key file; " +
+			"set NS1_KEYSTORE_PASSPHRASE when not running interactively")
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase for encrypted API key file: ")
+	p, err := xterm.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %v", err)
+	} else if len(p) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+
+	return p, nil
+}
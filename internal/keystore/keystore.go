@@ -0,0 +1,122 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keystore
+
+import (
+	"errors"
+	"os"
+
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService is the service name API keys are saved under in the OS
+// keyring. Each profile is a separate account.
+const keyringService = "pulsar-routemap"
+
+// Backends selectable with the NS1_KEYSTORE environment variable.
+const (
+	BackendKeyring = "keyring"
+	BackendFile    = "file"
+)
+
+// ErrNotFound is returned when no API key is saved for a profile.
+var ErrNotFound = errors.New("no saved API key")
+
+// Store saves NS1 API keys by profile name.
+type Store interface {
+	Get(profile string) (string, error)
+	Set(profile string, apiKey string) error
+	Delete(profile string) error
+}
+
+// New returns a store that uses the OS keyring (the Secret Service on Linux)
+// and falls back to an encrypted file when the keyring is unavailable. Set
+// NS1_KEYSTORE=file to always use the file.
+func New(filename string) Store {
+	file := &fileStore{filename: filename, passphrase: passphrase}
+
+	if os.Getenv("NS1_KEYSTORE") == BackendFile {
+		return file
+	}
+
+	return &fallbackStore{primary: keyringStore{}, fallback: file}
+}
+
+type keyringStore struct{}
+
+func (keyringStore) Get(profile string) (string, error) {
+	key, err := keyring.Get(keyringService, profile)
+	if err == keyring.ErrNotFound {
+		return "", ErrNotFound
+	}
+
+	return key, err
+}
+
+func (keyringStore) Set(profile string, apiKey string) error {
+	return keyring.Set(keyringService, profile, apiKey)
+}
+
+func (keyringStore) Delete(profile string) error {
+	err := keyring.Delete(keyringService, profile)
+	if err == keyring.ErrNotFound {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// fallbackStore uses the fallback store whenever the primary fails for a
+// reason other than the key not being there.
+type fallbackStore struct {
+	primary  Store
+	fallback Store
+}
+
+func (s *fallbackStore) Get(profile string) (string, error) {
+	key, err := s.primary.Get(profile)
+	if err == nil {
+		return key, nil
+	} else if err != ErrNotFound {
+		lg.Debugf("OS keyring unavailable (%v); using encrypted file", err)
+	}
+
+	// A key may have been saved to the file while the keyring was unavailable.
+	return s.fallback.Get(profile)
+}
+
+func (s *fallbackStore) Set(profile string, apiKey string) error {
+	if err := s.primary.Set(profile, apiKey); err != nil {
+		lg.Warnf("OS keyring unavailable (%v); saving to encrypted file instead", err)
+		return s.fallback.Set(profile, apiKey)
+	}
+
+	return nil
+}
+
+func (s *fallbackStore) Delete(profile string) error {
+	errPrimary := s.primary.Delete(profile)
+	errFallback := s.fallback.Delete(profile)
+
+	switch {
+	case errPrimary == nil || errFallback == nil:
+		return nil
+	case errPrimary == ErrNotFound:
+		return errFallback
+	default:
+		return errPrimary
+	}
+}
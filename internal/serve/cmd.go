@@ -0,0 +1,138 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serve
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/ns1/pulsar-routemap/internal/config"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+type Options struct {
+	Globals *config.CommandLineGlobals
+
+	Listen         string
+	MapFilename    string
+	ReloadInterval time.Duration
+	MaxBodyBytes   int64
+
+	MaxConcurrentValidations int
+}
+
+func AddCommands(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve route map validation and IP lookups over HTTP",
+		Long: "Serve route map validation and IP lookups over HTTP.\n\n" +
+			"POST /validate    validate the route map in the request body\n" +
+			"POST /lookup      find the labels for {\"addresses\": [...]} in the --map route map\n" +
+			"GET  /healthz     liveness check\n" +
+			"GET  /metrics     Prometheus metrics\n\n" +
+			"The --map file is reloaded when it changes. An invalid replacement is " +
+			"reported and the previous map stays in use.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunServeCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.StringVar(&opts.Listen, "listen", "127.0.0.1:8080",
+		"Address to listen on.")
+
+	flags.StringVar(&opts.MapFilename, "map", "",
+		"Route map file used to answer lookups. Without it, /lookup is unavailable.")
+
+	flags.DurationVar(&opts.ReloadInterval, "reload-interval", 5*time.Second,
+		"How often to check the --map file for changes.")
+
+	flags.Int64Var(&opts.MaxBodyBytes, "max-body-bytes", 0,
+		fmt.Sprintf("Largest request body, and largest route map after decompression, accepted. "+
+			"Default is --max-size-bytes if set, otherwise %d.", model.DefaultMaxSizeBytes))
+
+	flags.IntVar(&opts.MaxConcurrentValidations, "max-concurrent-validations", runtime.NumCPU(),
+		"Number of /validate requests handled at once; others wait. Each may use up to "+
+			"--max-body-bytes of memory for the map, and more once it is parsed.")
+
+	parentCmd.AddCommand(sub)
+}
+
+func RunServeCommand(opts *Options) error {
+	validatorOpts, err := opts.Globals.ValidatorOptions()
+	if err != nil {
+		return err
+	}
+
+	srv := NewServer(validatorOpts)
+	if opts.MaxBodyBytes > 0 {
+		srv.MaxBodyBytes = opts.MaxBodyBytes
+	}
+
+	if opts.MaxConcurrentValidations < 1 {
+		return fmt.Errorf("max-concurrent-validations must be at least 1, got %d", opts.MaxConcurrentValidations)
+	}
+	srv.MaxConcurrentValidations = opts.MaxConcurrentValidations
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if len(opts.MapFilename) > 0 {
+		if err = srv.LoadMap(opts.MapFilename); err != nil {
+			return err
+		}
+
+		go srv.WatchMap(opts.MapFilename, opts.ReloadInterval, stop)
+	}
+
+	// Reading a large map body may take a while on a slow link, so only the
+	// headers get a short deadline.
+	httpSrv := &http.Server{
+		Addr:              opts.Listen,
+		Handler:           srv,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       10 * time.Minute,
+		WriteTimeout:      10 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		lg.Printf("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpSrv.Shutdown(ctx)
+	}()
+
+	lg.Printf("route map service listening on http://%s", opts.Listen)
+	if err = httpSrv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
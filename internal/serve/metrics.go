@@ -0,0 +1,110 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serve
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// counterVec is a Prometheus counter with labels.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]uint64 // Keyed by label values joined with labelSep.
+}
+
+const labelSep = "\xff"
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]uint64{}}
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[strings.Join(values, labelSep)]++
+}
+
+func (c *counterVec) get(values ...string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[strings.Join(values, labelSep)]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var pairs []string
+		for i, v := range strings.Split(k, labelSep) {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", c.labels[i], v))
+		}
+
+		fmt.Fprintf(w, "%s{%s} %d\n", c.name, strings.Join(pairs, ","), c.values[k])
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+// metrics are exposed in the Prometheus text format on /metrics.
+type metrics struct {
+	requests  *counterVec
+	validates *counterVec
+	lookups   *counterVec
+	reloads   *counterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: newCounterVec("routemap_http_requests_total",
+			"HTTP requests by handler and status code.", "handler", "code"),
+		validates: newCounterVec("routemap_validations_total",
+			"Route maps submitted for validation by result.", "result"),
+		lookups: newCounterVec("routemap_lookups_total",
+			"Addresses looked up by result.", "result"),
+		reloads: newCounterVec("routemap_map_reloads_total",
+			"Loads of the lookup route map by result.", "result"),
+	}
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.requests.write(w)
+	m.validates.write(w)
+	m.lookups.write(w)
+	m.reloads.write(w)
+}
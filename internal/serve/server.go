@@ -0,0 +1,409 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serve
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/ns1/pulsar-routemap/pkg/lookup"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+	"go.uber.org/multierr"
+)
+
+// loadedMap is the route map used to answer lookups.
+type loadedMap struct {
+	table    *lookup.Table
+	sha1     string
+	segments int
+	modTime  time.Time
+	size     int64
+	loaded   time.Time
+}
+
+// Server serves the HTTP validation and lookup API.
+type Server struct {
+	// MaxBodyBytes limits request bodies and, for /validate, the
+	// decompressed route map.
+	MaxBodyBytes int64
+
+	// MaxConcurrentValidations limits how many /validate requests are
+	// processed at once, as each may hold a route map of up to MaxBodyBytes
+	// in memory. Further requests wait for their turn.
+	MaxConcurrentValidations int
+
+	validateSlots     chan struct{}
+	validateSlotsOnce sync.Once
+
+	opts    validator.Options
+	metrics *metrics
+	mux     *http.ServeMux
+
+	mu      sync.RWMutex
+	current *loadedMap
+}
+
+// NewServer creates a Server that validates maps with opts. Lookups are
+// refused until a map is loaded with LoadMap.
+func NewServer(opts validator.Options) *Server {
+	s := &Server{
+		MaxBodyBytes:             model.DefaultMaxSizeBytes,
+		MaxConcurrentValidations: runtime.NumCPU(),
+		opts:                     opts,
+		metrics:                  newMetrics(),
+		mux:                      http.NewServeMux(),
+	}
+
+	if opts.Limits.MaxSizeBytes > 0 {
+		s.MaxBodyBytes = int64(opts.Limits.MaxSizeBytes)
+	}
+
+	s.mux.Handle("/validate", s.instrument("validate", http.HandlerFunc(s.handleValidate)))
+	s.mux.Handle("/lookup", s.instrument("lookup", http.HandlerFunc(s.handleLookup)))
+	s.mux.Handle("/healthz", s.instrument("healthz", http.HandlerFunc(s.handleHealthz)))
+	s.mux.Handle("/metrics", s.instrument("metrics", http.HandlerFunc(s.handleMetrics)))
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// LoadMap loads and validates the named route map and, if it is valid, uses
+// it for all further lookups. The previous map stays in use on error.
+func (s *Server) LoadMap(filename string) error {
+	err := s.loadMap(filename)
+	if err != nil {
+		s.metrics.reloads.inc("failure")
+	} else {
+		s.metrics.reloads.inc("success")
+	}
+
+	return err
+}
+
+func (s *Server) loadMap(filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	root, err := model.LoadRoutemapFilename(filename)
+	if err != nil {
+		return err
+	}
+
+	if _, err = validator.ValidateRoot(root, s.opts); err != nil {
+		return fmt.Errorf("route map %s is invalid: %v", filename, err)
+	}
+
+	table, err := lookup.New(root)
+	if err != nil {
+		return err
+	}
+
+	m := &loadedMap{
+		table:    table,
+		sha1:     hex.EncodeToString(root.SHA1),
+		segments: len(root.Routemap),
+		modTime:  info.ModTime(),
+		size:     info.Size(),
+		loaded:   time.Now(),
+	}
+
+	s.mu.Lock()
+	s.current = m
+	s.mu.Unlock()
+
+	lg.With(lg.F("sha1", m.sha1), lg.F("networks", table.Len())).Infof("loaded route map %s", filename)
+	return nil
+}
+
+// WatchMap reloads the named route map whenever its modification time or size
+// changes, checking every interval until stop is closed.
+func (s *Server) WatchMap(filename string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastModTime time.Time
+	var lastSize int64
+	if m := s.loaded(); m != nil {
+		lastModTime, lastSize = m.modTime, m.size
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(filename)
+		if err != nil {
+			lg.Warnf("checking route map for changes: %v", err)
+			continue
+		}
+
+		if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+			continue
+		}
+
+		// Remember the change even on failure so a broken file is only
+		// reported once.
+		lastModTime, lastSize = info.ModTime(), info.Size()
+
+		if err = s.LoadMap(filename); err != nil {
+			lg.Warnf("reloading route map; keeping the previous map: %v", err)
+		}
+	}
+}
+
+func (s *Server) loaded() *loadedMap {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (s *Server) instrument(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		s.metrics.requests.inc(name, strconv.Itoa(rec.status))
+		lg.With(lg.F("status", rec.status)).Debugf("%s %s", r.Method, r.URL)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		lg.Warnf("writing response: %v", err)
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return false
+	}
+
+	return true
+}
+
+// limitBody refuses requests that declare a body larger than MaxBodyBytes
+// and stops reading bodies that turn out to be larger.
+func (s *Server) limitBody(w http.ResponseWriter, r *http.Request) bool {
+	if r.ContentLength > s.MaxBodyBytes {
+		writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{
+			Error: fmt.Sprintf("request body is larger than %d bytes", s.MaxBodyBytes),
+		})
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxBodyBytes)
+	return true
+}
+
+// ValidateResponse is the result of POST /validate.
+type ValidateResponse struct {
+	Valid       bool                   `json:"valid"`
+	SHA1        string                 `json:"sha1,omitempty"`
+	SizeInBytes int                    `json:"size,omitempty"`
+	Errors      []string               `json:"errors"`
+	Summary     *model.RoutemapSummary `json:"summary,omitempty"`
+}
+
+// acquireValidation waits for one of the MaxConcurrentValidations slots,
+// returning false if the request is cancelled first. The slot is given back
+// with releaseValidation.
+func (s *Server) acquireValidation(r *http.Request) bool {
+	s.validateSlotsOnce.Do(func() {
+		n := s.MaxConcurrentValidations
+		if n < 1 {
+			n = 1
+		}
+		s.validateSlots = make(chan struct{}, n)
+	})
+
+	select {
+	case s.validateSlots <- struct{}{}:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (s *Server) releaseValidation() {
+	<-s.validateSlots
+}
+
+// handleValidate validates the route map in the request body, which may be
+// compressed. The whole decompressed map is read into memory, so both it and
+// the body are limited to MaxBodyBytes, and only MaxConcurrentValidations
+// requests are handled at once.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) || !s.limitBody(w, r) {
+		return
+	}
+
+	if !s.acquireValidation(r) {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "cancelled while waiting to validate"})
+		return
+	}
+	defer s.releaseValidation()
+
+	root, err := model.LoadRoutemapLimit(r.Body, s.MaxBodyBytes)
+	if err == model.ErrRoutemapTooLarge {
+		s.metrics.validates.inc("unparsable")
+		writeJSON(w, http.StatusRequestEntityTooLarge, ValidateResponse{
+			Errors: []string{fmt.Sprintf("route map is larger than %d bytes", s.MaxBodyBytes)},
+		})
+		return
+	} else if err != nil {
+		s.metrics.validates.inc("unparsable")
+		writeJSON(w, http.StatusBadRequest, ValidateResponse{Errors: []string{err.Error()}})
+		return
+	}
+
+	summary, err := validator.ValidateRoot(root, s.opts)
+
+	resp := ValidateResponse{
+		Valid:       err == nil,
+		SHA1:        hex.EncodeToString(root.SHA1),
+		SizeInBytes: root.SizeInBytes,
+		Errors:      []string{},
+		Summary:     &summary,
+	}
+
+	for _, e := range multierr.Errors(err) {
+		resp.Errors = append(resp.Errors, e.Error())
+	}
+
+	if resp.Valid {
+		s.metrics.validates.inc("valid")
+	} else {
+		s.metrics.validates.inc("invalid")
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// LookupRequest is the body of POST /lookup.
+type LookupRequest struct {
+	Addresses []string `json:"addresses"`
+}
+
+// LookupResult is the result for one address.
+type LookupResult struct {
+	Address string `json:"address"`
+	Found   bool   `json:"found"`
+	Error   string `json:"error,omitempty"`
+	*lookup.Match
+}
+
+// LookupResponse is the result of POST /lookup.
+type LookupResponse struct {
+	SHA1    string         `json:"sha1"`
+	Results []LookupResult `json:"results"`
+}
+
+func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) || !s.limitBody(w, r) {
+		return
+	}
+
+	m := s.loaded()
+	if m == nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "no route map loaded"})
+		return
+	}
+
+	var req LookupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("parsing request: %v", err)})
+		return
+	}
+
+	resp := LookupResponse{SHA1: m.sha1, Results: make([]LookupResult, 0, len(req.Addresses))}
+
+	for _, addr := range req.Addresses {
+		result := LookupResult{Address: addr}
+
+		if ip := net.ParseIP(addr); ip == nil {
+			result.Error = "invalid IP address"
+			s.metrics.lookups.inc("invalid")
+		} else if match, ok := m.table.Lookup(ip); ok {
+			result.Found = true
+			result.Match = &match
+			s.metrics.lookups.inc("found")
+		} else {
+			s.metrics.lookups.inc("not_found")
+		}
+
+		resp.Results = append(resp.Results, result)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	s.metrics.write(w)
+
+	if m := s.loaded(); m != nil {
+		writeGauge(w, "routemap_map_networks", "Networks in the lookup route map.", float64(m.table.Len()))
+		writeGauge(w, "routemap_map_segments", "Segments in the lookup route map.", float64(m.segments))
+		writeGauge(w, "routemap_map_loaded_timestamp_seconds", "When the lookup route map was loaded.",
+			float64(m.loaded.Unix()))
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serve
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	goodMap = `{"meta":{"version":1},"map":[` +
+		`{"networks":["10.0.0.0/8"],"labels":["wide"]},` +
+		`{"networks":["10.1.0.0/16","2001:db8::/32"],"labels":["narrow"]}]}`
+
+	badMap = `{"meta":{"version":1},"map":[{"networks":["10.0.0.1/8"],"labels":["a","A"]}]}`
+)
+
+func post(t *testing.T, srv http.Handler, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	return rec
+}
+
+func writeMap(t *testing.T, filename, content string) {
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+}
+
+func Test_validate(t *testing.T) {
+	srv := NewServer(validator.Options{})
+
+	rec := post(t, srv, "/validate", goodMap)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp ValidateResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.True(t, resp.Valid)
+	assert.Empty(t, resp.Errors)
+	assert.Equal(t, 3, resp.Summary.NumNetworks)
+	assert.Equal(t, 2, resp.Summary.Labels["narrow"].Networks)
+	assert.Equal(t, "65536", resp.Summary.Labels["narrow"].IPv4Addrs.String())
+	assert.Equal(t, "4294967296", resp.Summary.Labels["narrow"].IPv6Nets64.String())
+	assert.Equal(t, map[int]int{8: 1, 16: 1}, resp.Summary.IPv4PrefixLengths)
+
+	rec = post(t, srv, "/validate", badMap)
+	require.Equal(t, http.StatusOK, rec.Code)
+	resp = ValidateResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.False(t, resp.Valid)
+	assert.Len(t, resp.Errors, 2)
+
+	rec = post(t, srv, "/validate", "{")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/validate", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	assert.Equal(t, uint64(1), srv.metrics.validates.get("valid"))
+	assert.Equal(t, uint64(1), srv.metrics.validates.get("invalid"))
+	assert.Equal(t, uint64(1), srv.metrics.validates.get("unparsable"))
+}
+
+func Test_bodyLimit(t *testing.T) {
+	srv := NewServer(validator.Options{})
+	srv.MaxBodyBytes = int64(len(goodMap))
+
+	rec := post(t, srv, "/validate", goodMap)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = post(t, srv, "/validate", goodMap+" ")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	// Bodies of unknown length are cut off at the limit.
+	req := httptest.NewRequest("POST", "/validate", ioutil.NopCloser(strings.NewReader(goodMap+" ")))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A small compressed body may not expand beyond the limit.
+	srv.MaxBodyBytes = 1000
+	padded := strings.Replace(goodMap, `"map":`, strings.Repeat(" ", 10000)+`"map":`, 1)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(padded))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.Less(t, int64(buf.Len()), srv.MaxBodyBytes)
+
+	rec = post(t, srv, "/validate", buf.String())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func Test_validateConcurrency(t *testing.T) {
+	srv := NewServer(validator.Options{})
+	srv.MaxConcurrentValidations = 1
+
+	// Take the only slot.
+	require.True(t, srv.acquireValidation(httptest.NewRequest("POST", "/validate", nil)))
+
+	done := make(chan int)
+	go func() { done <- post(t, srv, "/validate", goodMap).Code }()
+
+	select {
+	case <-done:
+		t.Fatal("validation ran while the only slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A request that gives up while waiting is not validated.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("POST", "/validate", strings.NewReader(goodMap)).WithContext(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	srv.releaseValidation()
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func Test_lookup(t *testing.T) {
+	srv := NewServer(validator.Options{})
+
+	rec := post(t, srv, "/lookup", `{"addresses":["10.1.2.3"]}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	filename := filepath.Join(t.TempDir(), "map.json")
+	writeMap(t, filename, goodMap)
+	require.NoError(t, srv.LoadMap(filename))
+
+	rec = post(t, srv, "/lookup", `{"addresses":["10.1.2.3","10.2.3.4","192.0.2.1","bogus"]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp LookupResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 4)
+
+	assert.True(t, resp.Results[0].Found)
+	assert.Equal(t, "10.1.0.0/16", resp.Results[0].Network)
+	assert.Equal(t, []string{"narrow"}, resp.Results[0].Labels)
+	assert.Equal(t, "10.0.0.0/8", resp.Results[1].Network)
+	assert.False(t, resp.Results[2].Found)
+	assert.Equal(t, "invalid IP address", resp.Results[3].Error)
+
+	rec = post(t, srv, "/lookup", `not json`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func Test_WatchMap(t *testing.T) {
+	srv := NewServer(validator.Options{})
+
+	filename := filepath.Join(t.TempDir(), "map.json")
+	writeMap(t, filename, goodMap)
+	require.NoError(t, srv.LoadMap(filename))
+	first := srv.loaded()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go srv.WatchMap(filename, 10*time.Millisecond, stop)
+
+	// An invalid map is not used.
+	writeMap(t, filename, badMap)
+	require.NoError(t, os.Chtimes(filename, time.Now(), time.Now().Add(time.Second)))
+	assert.Eventually(t, func() bool { return srv.metrics.reloads.get("failure") == 1 },
+		time.Second, 10*time.Millisecond)
+	assert.Equal(t, first, srv.loaded())
+
+	updated := strings.Replace(goodMap, "10.1.0.0/16", "10.1.2.0/24", 1)
+	writeMap(t, filename, updated)
+	require.NoError(t, os.Chtimes(filename, time.Now(), time.Now().Add(2*time.Second)))
+	assert.Eventually(t, func() bool { return srv.metrics.reloads.get("success") == 2 },
+		time.Second, 10*time.Millisecond)
+
+	m, ok := srv.loaded().table.Lookup([]byte{10, 1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, "10.1.2.0/24", m.Network)
+}
+
+func Test_metricsAndHealthz(t *testing.T) {
+	srv := NewServer(validator.Options{})
+
+	filename := filepath.Join(t.TempDir(), "map.json")
+	writeMap(t, filename, goodMap)
+	require.NoError(t, srv.LoadMap(filename))
+
+	post(t, srv, "/lookup", `{"addresses":["10.1.2.3"]}`)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, "ok\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	assert.Contains(t, body, "# TYPE routemap_http_requests_total counter\n")
+	assert.Contains(t, body, `routemap_http_requests_total{handler="lookup",code="200"} 1`)
+	assert.Contains(t, body, `routemap_http_requests_total{handler="healthz",code="200"} 1`)
+	assert.Contains(t, body, `routemap_lookups_total{result="found"} 1`)
+	assert.Contains(t, body, `routemap_map_reloads_total{result="success"} 1`)
+	assert.Contains(t, body, "routemap_map_networks 3\n")
+	assert.Contains(t, body, "routemap_map_segments 2\n")
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ns1/pulsar-routemap/internal/config"
+	"github.com/ns1/pulsar-routemap/pkg/answers"
+	"github.com/ns1/pulsar-routemap/pkg/lookup"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+type Options struct {
+	Globals *config.CommandLineGlobals
+
+	MapFilename     string
+	AnswersFilename string
+	Clients         []string
+	ClientsFilename string
+	Unmatched       string
+	OutputFormat    string
+}
+
+func (o *Options) validate() error {
+	var allErrs error
+
+	if len(o.MapFilename) == 0 {
+		multierr.AppendInto(&allErrs, fmt.Errorf("map parameter is required"))
+	}
+	if len(o.AnswersFilename) == 0 {
+		multierr.AppendInto(&allErrs, fmt.Errorf("answers parameter is required"))
+	}
+	if len(o.Clients) == 0 && len(o.ClientsFilename) == 0 {
+		multierr.AppendInto(&allErrs, fmt.Errorf("client or clients-file parameter is required"))
+	}
+	if o.OutputFormat != OutputTable && o.OutputFormat != OutputJSON {
+		multierr.AppendInto(&allErrs,
+			fmt.Errorf("invalid output format '%s'; must be table or json", o.OutputFormat))
+	}
+
+	_, err := answers.ParseUnmatched(o.Unmatched)
+	multierr.AppendInto(&allErrs, err)
+
+	return allErrs
+}
+
+func AddCommands(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "simulate",
+		Short: "Show the ordered DNS answers client subnets would receive",
+		Long: "Show the ordered DNS answers client subnets would receive.\n\n" +
+			"Each client is looked up in the route map as if a resolver had sent its " +
+			"subnet in the EDNS Client Subnet option. Answers whose label is in the " +
+			"matching map segment are listed in the order of the segment's labels; the " +
+			"others are listed as dropped.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validate()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunSimulateCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.StringVar(&opts.MapFilename, "map", "",
+		"Route map file. May be compressed with gzip, bzip2 or zstd.")
+
+	flags.StringVar(&opts.AnswersFilename, "answers", "",
+		"YAML or JSON file listing the answers and their labels.")
+
+	flags.StringArrayVar(&opts.Clients, "client", nil,
+		"Client subnet in CIDR form, or a single address. Repeatable.")
+
+	flags.StringVar(&opts.ClientsFilename, "clients-file", "",
+		"File listing client subnets, one per line, or '-' for STDIN.")
+
+	flags.StringVar(&opts.Unmatched, "unmatched", string(answers.UnmatchedAll),
+		"Answers for clients outside of every map segment: all, or none.")
+
+	flags.StringVarP(&opts.OutputFormat, "output", "o", OutputTable,
+		"Output format. One of: table, json.")
+
+	parentCmd.AddCommand(sub)
+}
+
+func RunSimulateCommand(opts *Options) error {
+	validatorOpts, err := opts.Globals.ValidatorOptions()
+	if err != nil {
+		return err
+	}
+
+	root, _, err := validator.LoadAndValidateWithOptions(opts.MapFilename, validatorOpts)
+	if err != nil {
+		return fmt.Errorf("route map %s is invalid: %v", opts.MapFilename, err)
+	}
+
+	table, err := lookup.New(root)
+	if err != nil {
+		return err
+	}
+
+	all, err := answers.LoadFile(opts.AnswersFilename)
+	if err != nil {
+		return err
+	}
+
+	clients := opts.Clients
+	if len(opts.ClientsFilename) > 0 {
+		more, err := readClients(opts.ClientsFilename)
+		if err != nil {
+			return err
+		}
+		clients = append(clients, more...)
+	}
+
+	unmatched, _ := answers.ParseUnmatched(opts.Unmatched)
+
+	results, err := Simulate(table, all, unmatched, clients)
+	if err != nil {
+		return err
+	}
+
+	if opts.OutputFormat == OutputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	return printTable(os.Stdout, results)
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ns1/pulsar-routemap/pkg/answers"
+	"github.com/ns1/pulsar-routemap/pkg/lookup"
+)
+
+// Result is what one client subnet receives.
+type Result struct {
+	Client string `json:"client"`
+	Found  bool   `json:"found"`
+	*lookup.Match
+
+	Answers []answers.Answer `json:"answers"`
+	Dropped []answers.Answer `json:"dropped"`
+}
+
+// parseClient parses a subnet in CIDR form or a single address and returns
+// the address a resolver would send in the EDNS Client Subnet option.
+func parseClient(client string) (net.IP, error) {
+	if ip := net.ParseIP(client); ip != nil {
+		return ip, nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(client)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client subnet '%s'", client)
+	}
+
+	return ipnet.IP, nil
+}
+
+// Simulate finds the answers each client receives.
+func Simulate(table *lookup.Table, all []answers.Answer, unmatched answers.Unmatched, clients []string) ([]Result, error) {
+	results := make([]Result, 0, len(clients))
+
+	for _, client := range clients {
+		ip, err := parseClient(client)
+		if err != nil {
+			return nil, err
+		}
+
+		r := Result{Client: client}
+
+		if match, ok := table.Lookup(ip); ok {
+			r.Found = true
+			r.Match = &match
+			r.Answers, r.Dropped = answers.Order(all, match.Labels)
+		} else {
+			r.Answers, r.Dropped = unmatched.Apply(all)
+		}
+
+		// Always output lists, even if empty.
+		if r.Answers == nil {
+			r.Answers = []answers.Answer{}
+		}
+		if r.Dropped == nil {
+			r.Dropped = []answers.Answer{}
+		}
+
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// readClients reads client subnets from the named file, or STDIN for "-", one
+// per line. Blank lines and lines starting with '#' are ignored.
+func readClients(filename string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var clients []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		clients = append(clients, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading clients file: %v", err)
+	}
+
+	return clients, nil
+}
+
+func joinAnswers(list []answers.Answer, withLabels bool) string {
+	if len(list) == 0 {
+		return "-"
+	}
+
+	var values []string
+	for _, a := range list {
+		if withLabels {
+			values = append(values, fmt.Sprintf("%s (%s)", a.Answer, a.Label))
+		} else {
+			values = append(values, a.Answer)
+		}
+	}
+
+	return strings.Join(values, " ")
+}
+
+func printTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 8, 8, 1, ' ', 0)
+
+	pp := func(values ...string) {
+		line := strings.Join(values, "\t")
+		fmt.Fprintf(tw, "%s\t\n", line)
+	}
+
+	pp("client", "network", "segment", "labels", "answers", "dropped")
+	pp("------", "-------", "-------", "------", "-------", "-------")
+
+	var numUnmatched, numEmpty int
+	for _, r := range results {
+		network, segment, labels := "-", "-", "-"
+		if r.Found {
+			network = r.Network
+			segment = fmt.Sprintf("%d", r.Segment)
+			labels = strings.Join(r.Labels, ",")
+		} else {
+			numUnmatched++
+		}
+
+		if len(r.Answers) == 0 {
+			numEmpty++
+		}
+
+		pp(r.Client, network, segment, labels, joinAnswers(r.Answers, false), joinAnswers(r.Dropped, true))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d clients: %d in no map segment, %d receive no answers.\n",
+		len(results), numUnmatched, numEmpty)
+	return err
+}
@@ -0,0 +1,361 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/internal/config"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/transform"
+	"github.com/spf13/cobra"
+)
+
+// Manifest formats supported by the split command.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+type Options struct {
+	Globals *config.CommandLineGlobals
+
+	InputFilenames []string
+	OutputFilename string
+
+	// Split options.
+	Split          transform.SplitOptions
+	MaxBytes       int
+	ManifestFormat string
+
+	// Filter options.
+	Filter       transform.Filter
+	LabelRegexp  string
+	Within       []string
+	PrefixLength string
+	List         bool
+
+	// Relabel options.
+	Relabel           transform.Relabel
+	MapRules          []string
+	DropLabels        []string
+	MappingFilename   string
+	CompleteFallbacks bool
+}
+
+func (o *Options) validateMerge(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("at least two route maps are required")
+	}
+	if len(o.OutputFilename) == 0 {
+		return fmt.Errorf("output parameter is required")
+	}
+
+	for _, name := range args {
+		if name == o.OutputFilename {
+			return fmt.Errorf("output file %s is also an input", name)
+		}
+	}
+
+	o.InputFilenames = args
+	return nil
+}
+
+func (o *Options) validateSplit(args []string) error {
+	if len(o.OutputFilename) == 0 {
+		return fmt.Errorf("output parameter is required")
+	}
+	if o.ManifestFormat != OutputTable && o.ManifestFormat != OutputJSON {
+		return fmt.Errorf("invalid manifest format '%s'; must be table or json", o.ManifestFormat)
+	}
+
+	o.InputFilenames = args
+	return o.Split.Validate()
+}
+
+// splitLimits returns the limits for each part: --max-bytes, or else the
+// global limits, or else the default customer limits.
+func (o *Options) splitLimits() model.Limits {
+	limits := o.Globals.Limits
+
+	if o.MaxBytes > 0 {
+		limits.MaxSizeBytes = o.MaxBytes
+	}
+	if limits.MaxSizeBytes == 0 {
+		limits.MaxSizeBytes = model.DefaultMaxSizeBytes
+	}
+	if limits.MaxSegments == 0 {
+		limits.MaxSegments = model.DefaultMaxSegments
+	}
+
+	return limits
+}
+
+func (o *Options) validateFilter(args []string) error {
+	var err error
+
+	if len(o.LabelRegexp) > 0 {
+		if o.Filter.LabelRegexp, err = regexp.Compile(o.LabelRegexp); err != nil {
+			return fmt.Errorf("invalid label regexp: %v", err)
+		}
+	}
+
+	for _, w := range o.Within {
+		p, err := netip.ParsePrefix(w)
+		if err != nil {
+			return fmt.Errorf("invalid network '%s' for within", w)
+		}
+		o.Filter.Within = append(o.Filter.Within, p.Masked())
+	}
+
+	switch o.Filter.Family {
+	case "", transform.FamilyIPv4, transform.FamilyIPv6:
+	default:
+		return fmt.Errorf("invalid family '%s'; must be ipv4 or ipv6", o.Filter.Family)
+	}
+
+	o.Filter.MaxBits = transform.NoMaxBits
+	if len(o.PrefixLength) > 0 {
+		if o.Filter.MinBits, o.Filter.MaxBits, err = transform.ParseBitsRange(o.PrefixLength); err != nil {
+			return err
+		}
+	}
+
+	o.InputFilenames = args
+	return nil
+}
+
+func (o *Options) validateRelabel(args []string) error {
+	if len(o.OutputFilename) == 0 {
+		return fmt.Errorf("output parameter is required")
+	}
+	if len(o.MapRules) == 0 && len(o.DropLabels) == 0 && len(o.MappingFilename) == 0 && !o.CompleteFallbacks {
+		return fmt.Errorf("map, drop, mapping-file or complete-fallbacks parameter is required")
+	}
+	if o.CompleteFallbacks && len(o.Globals.FallbackPolicy) == 0 {
+		return fmt.Errorf("complete-fallbacks requires a fallback policy")
+	}
+
+	o.Relabel.Rules = nil
+
+	for _, m := range o.MapRules {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("invalid map '%s' (expected old=new)", m)
+		}
+
+		o.Relabel.Rules = append(o.Relabel.Rules, transform.RelabelRule{Old: parts[0], New: parts[1], Source: "--map"})
+	}
+
+	for _, d := range o.DropLabels {
+		o.Relabel.Rules = append(o.Relabel.Rules, transform.RelabelRule{Old: d, Source: "--drop"})
+	}
+
+	o.InputFilenames = args
+	return nil
+}
+
+func AddCommands(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	addMergeCommand(parentCmd, globals)
+	addSplitCommand(parentCmd, globals)
+	addFilterCommand(parentCmd, globals)
+	addRelabelCommand(parentCmd, globals)
+}
+
+func addMergeCommand(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "merge <map> <map> [<map> ...]",
+		Short: "Merge route maps, with later maps taking precedence",
+		Long: strings.Join([]string{
+			"Merge route maps, with later maps taking precedence.\n",
+			"Each address is given the labels of the last map with a network containing it. " +
+				"Within a map, the most specific network containing the address wins. Where a " +
+				"later map takes over part of a network, the rest of the network is split so " +
+				"that the merged map covers exactly the same addresses without overlapping " +
+				"networks.\n",
+			"Networks that were overridden are listed, along with the network and map that " +
+				"overrode them.",
+		}, "\n"),
+		Example: "  routemap merge base.json overrides.json pins.json -o out.json",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateMerge(args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunMergeCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.StringVarP(&opts.OutputFilename, "output", "o", "",
+		"File to write the merged route map to.")
+
+	parentCmd.AddCommand(sub)
+}
+
+func addSplitCommand(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "split [<map>]",
+		Short: "Split a route map into several that are within the map limits",
+		Long: strings.Join([]string{
+			"Split a route map into several that are within the map limits.\n",
+			"Each part is within --max-segments and --max-bytes, which default to the limits " +
+				"for customers. Every network is in exactly one part, with the labels of its " +
+				"segment. Segments are only divided when one is too big for a part on its own.\n",
+			"With --by family, IPv4 and IPv6 networks are put in separate parts. With --by " +
+				"label, segments are grouped by their first label.\n",
+			"Parts are named after the output file, e.g. -o part.json writes part-1.json, " +
+				"part-2.json and so on, or part-ipv4-1.json with --by family. A manifest of the " +
+				"parts is printed. The map is read from STDIN if no file is given.",
+		}, "\n"),
+		Example: "  routemap split big.json --max-segments 50000 --max-bytes 100000000 -o part.json",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateSplit(args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunSplitCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.StringVarP(&opts.OutputFilename, "output", "o", "",
+		"Name of the output files, which are numbered.")
+
+	flags.IntVar(&opts.MaxBytes, "max-bytes", 0,
+		fmt.Sprintf("Size limit for each part. Default is --max-size-bytes if set, or else %d.",
+			model.DefaultMaxSizeBytes))
+
+	flags.StringVar(&opts.Split.By, "by", "",
+		"Keep networks apart by family (IPv4 and IPv6), or segments apart by their first label.")
+
+	flags.StringVar(&opts.ManifestFormat, "manifest-format", OutputTable,
+		"Format of the manifest. One of: table, json.")
+
+	parentCmd.AddCommand(sub)
+}
+
+func addFilterCommand(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "filter [<map>]",
+		Short: "Extract the segments and networks of a route map that match",
+		Long: strings.Join([]string{
+			"Extract the segments and networks of a route map that match.\n",
+			"Segments are selected by their labels, and networks within them by address " +
+				"family, prefix length and the networks they are within. All of the given " +
+				"conditions must match. Labels are compared without regard to case. Segments " +
+				"left without networks are dropped.\n",
+			"The result is written as a route map, or with --list as one line per network " +
+				"with the index of its segment in the input and its labels. The map is read " +
+				"from STDIN if no file is given.",
+		}, "\n"),
+		Example: "  routemap filter big.json --within 10.0.0.0/8 --has-label syd --list\n" +
+			"  routemap filter big.json --family ipv6 --prefix-length 48-64 -o v6.json",
+		Args: cobra.MaximumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateFilter(args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunFilterCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.StringVarP(&opts.OutputFilename, "output", "o", "",
+		"File to write the route map or listing to. Default is STDOUT.")
+
+	flags.StringArrayVar(&opts.Filter.HasLabels, "has-label", nil,
+		"Keep segments with this label. Repeatable; segments must have every one.")
+
+	flags.StringArrayVar(&opts.Filter.PrimaryLabels, "primary-label", nil,
+		"Keep segments whose first label is this. Repeatable; the first label may be any of them.")
+
+	flags.StringVar(&opts.LabelRegexp, "label-regexp", "",
+		"Keep segments with a label matching this regular expression, e.g. '^(syd|mel)'.")
+
+	flags.StringArrayVar(&opts.Within, "within", nil,
+		"Keep networks within this network, e.g. 10.0.0.0/8. Repeatable; networks may be "+
+			"within any of them.")
+
+	flags.StringVar(&opts.Filter.Family, "family", "",
+		"Keep networks of this address family: ipv4 or ipv6.")
+
+	flags.StringVar(&opts.PrefixLength, "prefix-length", "",
+		"Keep networks with a prefix length in this range, e.g. 24, 16-24, -16 or 48-.")
+
+	flags.BoolVar(&opts.List, "list", false,
+		"List the matching networks instead of writing a route map.")
+
+	parentCmd.AddCommand(sub)
+}
+
+func addRelabelCommand(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
+	opts := &Options{Globals: globals}
+	sub := &cobra.Command{
+		Use:   "relabel [<map>]",
+		Short: "Rename or drop labels throughout a route map",
+		Long: strings.Join([]string{
+			"Rename or drop labels throughout a route map.\n",
+			"Labels keep their order in each segment. Old labels are matched without regard " +
+				"to case, and each label is renamed at most once. Where a renamed label is " +
+				"already in the segment, only the first is kept. Segments that end up with the " +
+				"same labels are merged.\n",
+			"The mapping file is CSV with a line of old,new for each label. Leave new empty to " +
+				"drop the label. The number of segments changed by each rule is printed. The " +
+				"map is read from STDIN if no file is given.\n",
+			"With --complete-fallbacks, the fallback labels that the --fallback-policy requires " +
+				"for each segment's first label are added to the end of the segment, in order. " +
+				"Fallback labels elsewhere in the segment are moved to the end.",
+		}, "\n"),
+		Example: "  routemap relabel big.json --map syd=sydney --drop per --mapping-file renames.csv -o out.json",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateRelabel(args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunRelabelCommand(opts)
+		},
+	}
+
+	flags := sub.Flags()
+
+	flags.StringVarP(&opts.OutputFilename, "output", "o", "",
+		"File to write the relabelled route map to.")
+
+	flags.StringArrayVar(&opts.MapRules, "map", nil,
+		"Rename a label, as old=new. Repeatable.")
+
+	flags.StringArrayVar(&opts.DropLabels, "drop", nil,
+		"Remove a label from every segment. Repeatable.")
+
+	flags.StringVar(&opts.MappingFilename, "mapping-file", "",
+		"CSV file of old,new label pairs.")
+
+	flags.BoolVar(&opts.CompleteFallbacks, "complete-fallbacks", false,
+		"End each segment with the fallback labels required by --fallback-policy.")
+
+	flags.BoolVar(&opts.Relabel.DropEmpty, "drop-empty-segments", false,
+		"Drop segments, and their networks, that are left without labels. Default is to fail.")
+
+	parentCmd.AddCommand(sub)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+)
+
+func RunFilterCommand(opts *Options) error {
+	var filename string
+	if len(opts.InputFilenames) > 0 {
+		filename = opts.InputFilenames[0]
+	}
+
+	root, err := model.LoadRoutemapFileOrStdin(filename)
+	if err != nil {
+		return err
+	}
+
+	filtered, indexes, err := opts.Filter.Apply(root)
+	if err != nil {
+		return err
+	}
+
+	var networks int
+	for _, m := range filtered.Routemap {
+		networks += len(m.Networks)
+	}
+
+	if opts.List {
+		err = writeOutput(opts.OutputFilename, func(w io.Writer) error {
+			return printListing(w, filtered, indexes)
+		})
+	} else {
+		var out *model.RoutemapRoot
+		var validatorOpts validator.Options
+		if validatorOpts, err = opts.Globals.ValidatorOptions(); err != nil {
+			return err
+		}
+
+		if out, _, err = encodeRoutemap(filtered, validatorOpts); err != nil {
+			return err
+		}
+
+		err = writeOutput(opts.OutputFilename, func(w io.Writer) error {
+			_, err := w.Write(out.Raw)
+			return err
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lg.Printf("matched %d networks in %d of %d segments", networks, len(filtered.Routemap), len(root.Routemap))
+	return nil
+}
+
+// writeOutput calls write with the named file, or STDOUT if there is no name.
+func writeOutput(filename string, write func(w io.Writer) error) error {
+	if len(filename) == 0 {
+		return write(os.Stdout)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err = write(f); err != nil {
+		f.Close()
+		os.Remove(filename)
+		return err
+	}
+
+	return f.Close()
+}
+
+// printListing writes a line for each network: the network, the index of its
+// segment in the input and its labels.
+func printListing(w io.Writer, root *model.RoutemapRoot, indexes []int) error {
+	buf := bufio.NewWriter(w)
+
+	for i, m := range root.Routemap {
+		labels := strings.Join(m.Labels, ",")
+		for _, n := range m.Networks {
+			fmt.Fprintf(buf, "%s\t%d\t%s\n", n, indexes[i], labels)
+		}
+	}
+
+	return buf.Flush()
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ns1/pulsar-routemap/internal/validate"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/ns1/pulsar-routemap/pkg/transform"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+)
+
+func RunMergeCommand(opts *Options) error {
+	validatorOpts, err := opts.Globals.ValidatorOptions()
+	if err != nil {
+		return err
+	}
+
+	var sources []transform.Source
+
+	for _, name := range opts.InputFilenames {
+		root, _, err := validator.LoadAndValidate(name)
+		if err != nil {
+			return fmt.Errorf("route map %s is invalid: %v", name, validate.PrettyPrintErrors(err))
+		}
+
+		sources = append(sources, transform.Source{Name: name, Root: root})
+	}
+
+	merged, overrides, err := transform.Merge(sources)
+	if err != nil {
+		return err
+	}
+
+	out, summary, err := encodeRoutemap(merged, validatorOpts)
+	if err != nil {
+		return err
+	}
+
+	if err = writeFile(opts.OutputFilename, out.Raw); err != nil {
+		return err
+	}
+
+	lg.Infof("wrote %s: %d bytes (sha1 %s)", opts.OutputFilename, out.SizeInBytes, hex.EncodeToString(out.SHA1))
+
+	printOverrides(os.Stdout, overrides)
+	fmt.Printf("merged %d maps into %d segments, %d networks; %d networks overridden\n",
+		len(sources), len(out.Routemap), summary.NumNetworks, len(overrides))
+
+	return nil
+}
+
+func printOverrides(w io.Writer, overrides []transform.Override) {
+	for _, o := range overrides {
+		fmt.Fprintf(w, "%s\n", o)
+	}
+}
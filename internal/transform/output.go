@@ -0,0 +1,75 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ns1/pulsar-routemap/internal/validate"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+)
+
+// encodeRoutemap serializes root and validates the result, returning it as
+// loaded back so that its SHA1 and size are those of the output.
+func encodeRoutemap(root *model.RoutemapRoot, opts validator.Options) (*model.RoutemapRoot, model.RoutemapSummary, error) {
+	var buf bytes.Buffer
+	if _, err := root.WriteTo(&buf); err != nil {
+		return nil, model.RoutemapSummary{}, err
+	}
+
+	out, err := model.LoadRoutemap(&buf)
+	if err != nil {
+		return nil, model.RoutemapSummary{}, err
+	}
+
+	summary, err := validator.ValidateRoot(out, opts)
+	if err != nil {
+		return nil, summary, fmt.Errorf("output route map is invalid: %v", validate.PrettyPrintErrors(err))
+	}
+
+	return out, summary, nil
+}
+
+// writeFile replaces filename with data, leaving any existing file untouched
+// if writing fails.
+func writeFile(filename string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/transform"
+)
+
+func RunRelabelCommand(opts *Options) error {
+	if len(opts.MappingFilename) > 0 {
+		rules, err := transform.LoadRelabelRules(opts.MappingFilename)
+		if err != nil {
+			return err
+		}
+		opts.Relabel.Rules = append(opts.Relabel.Rules, rules...)
+	}
+
+	validatorOpts, err := opts.Globals.ValidatorOptions()
+	if err != nil {
+		return err
+	}
+
+	if opts.CompleteFallbacks {
+		opts.Relabel.Fallbacks = validatorOpts.Policy
+	}
+
+	var filename string
+	if len(opts.InputFilenames) > 0 {
+		filename = opts.InputFilenames[0]
+	}
+
+	root, err := model.LoadRoutemapFileOrStdin(filename)
+	if err != nil {
+		return err
+	}
+
+	relabelled, stats, err := opts.Relabel.Apply(root)
+	if err != nil {
+		return err
+	}
+
+	out, _, err := encodeRoutemap(relabelled, validatorOpts)
+	if err != nil {
+		return err
+	}
+
+	if err = writeFile(opts.OutputFilename, out.Raw); err != nil {
+		return err
+	}
+
+	printRelabelStats(os.Stdout, opts.Relabel.Rules, stats)
+	return nil
+}
+
+func printRelabelStats(w io.Writer, rules []transform.RelabelRule, stats transform.RelabelStats) {
+	for i, rule := range rules {
+		fmt.Fprintf(w, "%s: %d segments\n", rule, stats.Rules[i])
+	}
+
+	fmt.Fprintf(w, "changed %d segments; removed %d duplicate labels; merged %d segments",
+		stats.SegmentsChanged, stats.LabelsDeduplicated, stats.SegmentsMerged)
+
+	if stats.FallbacksCompleted > 0 {
+		fmt.Fprintf(w, "; completed fallbacks of %d segments", stats.FallbacksCompleted)
+	}
+
+	if stats.SegmentsDropped > 0 {
+		fmt.Fprintf(w, "; dropped %d segments with %d networks", stats.SegmentsDropped, stats.NetworksDropped)
+	}
+
+	fmt.Fprintln(w)
+}
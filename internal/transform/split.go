@@ -0,0 +1,186 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ns1/pulsar-routemap/internal/validate"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/transform"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+)
+
+// ManifestEntry describes one of the files written by the split command.
+type ManifestEntry struct {
+	File        string                `json:"file"`
+	Key         string                `json:"key,omitempty"`
+	SHA1        string                `json:"sha1"`
+	SizeInBytes int                   `json:"size"`
+	Segments    int                   `json:"segments"`
+	Summary     model.RoutemapSummary `json:"summary"`
+}
+
+func RunSplitCommand(opts *Options) error {
+	validatorOpts, err := opts.Globals.ValidatorOptions()
+	if err != nil {
+		return err
+	}
+
+	var filename string
+	if len(opts.InputFilenames) > 0 {
+		filename = opts.InputFilenames[0]
+	}
+
+	root, _, err := validator.LoadAndValidate(filename)
+	if err != nil {
+		return fmt.Errorf("route map is invalid: %v", validate.PrettyPrintErrors(err))
+	}
+
+	opts.Split.Limits = opts.splitLimits()
+	validatorOpts.Limits = opts.Split.Limits
+	lg.Infof("splitting into maps of at most %d segments and %d bytes",
+		opts.Split.Limits.MaxSegments, opts.Split.Limits.MaxSizeBytes)
+
+	parts, err := transform.Split(root, opts.Split)
+	if err != nil {
+		return err
+	}
+
+	names := partFilenames(opts.OutputFilename, parts)
+	outs := make([]*model.RoutemapRoot, len(parts))
+	manifest := make([]ManifestEntry, len(parts))
+
+	// Check every part before writing any of them.
+	for i, p := range parts {
+		out, summary, err := encodeRoutemap(p.Root, validatorOpts)
+		if err != nil {
+			return fmt.Errorf("%s: %v", names[i], err)
+		}
+
+		outs[i] = out
+		manifest[i] = ManifestEntry{
+			File:        names[i],
+			Key:         p.Key,
+			SHA1:        hex.EncodeToString(out.SHA1),
+			SizeInBytes: out.SizeInBytes,
+			Segments:    len(out.Routemap),
+			Summary:     summary,
+		}
+	}
+
+	for i, out := range outs {
+		if err = writeFile(names[i], out.Raw); err != nil {
+			return err
+		}
+	}
+
+	if opts.ManifestFormat == OutputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(manifest)
+	}
+
+	return printManifest(os.Stdout, manifest)
+}
+
+// partFilenames numbers the parts within each group, e.g. part-1.json or
+// part-ipv4-1.json for an output filename of part.json.
+func partFilenames(output string, parts []transform.Part) []string {
+	ext := filepath.Ext(output)
+	stem := strings.TrimSuffix(output, ext)
+	if len(ext) == 0 {
+		ext = ".json"
+	}
+
+	var (
+		names  []string
+		counts = make(map[string]int)
+		stems  = make(map[string]string)
+		used   = make(map[string]bool)
+	)
+
+	for _, p := range parts {
+		s, ok := stems[p.Key]
+		if !ok {
+			s = stem
+			if len(p.Key) > 0 {
+				s += "-" + safeFilename(p.Key)
+			}
+
+			// Labels that differ only in characters unsafe for file
+			// names would otherwise share files.
+			for base, n := s, 2; used[s]; n++ {
+				s = fmt.Sprintf("%s_%d", base, n)
+			}
+
+			used[s] = true
+			stems[p.Key] = s
+		}
+
+		counts[p.Key]++
+		names = append(names, fmt.Sprintf("%s-%d%s", s, counts[p.Key], ext))
+	}
+
+	return names
+}
+
+// safeFilename replaces characters other than letters, digits, '.', '-' and
+// '_' with '_'.
+func safeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+func printManifest(w io.Writer, manifest []ManifestEntry) error {
+	tw := tabwriter.NewWriter(w, 8, 8, 1, ' ', 0)
+
+	pp := func(values ...string) {
+		line := strings.Join(values, "\t")
+		fmt.Fprintf(tw, "%s\t\n", line)
+	}
+
+	pp("file", "segments", "networks", "ipv4", "ipv6", "bytes", "sha1")
+	pp("----", "--------", "--------", "----", "----", "-----", "----")
+
+	var networks int
+	for _, m := range manifest {
+		s := m.Summary
+		pp(m.File, fmt.Sprint(m.Segments), fmt.Sprint(s.NumNetworks), fmt.Sprint(s.NumIPv4),
+			fmt.Sprint(s.NumIPv6), fmt.Sprint(m.SizeInBytes), m.SHA1)
+		networks += s.NumNetworks
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d maps, %d networks.\n", len(manifest), networks)
+	return err
+}
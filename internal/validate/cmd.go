@@ -42,14 +42,19 @@ func AddCommands(parentCmd *cobra.Command, globals *config.CommandLineGlobals) {
 	flags := sub.Flags()
 
 	flags.StringVar(&opts.InputFilename, "file", "",
-		"Route map file to validate. Default is STDIN.")
+		"Route map file to validate. Default is STDIN. May be compressed with gzip, bzip2 or zstd.")
 
 	parentCmd.AddCommand(sub)
 }
 
 func RunValidateCommand(opts *Options) error {
 	lg.Infof("reading route map from '%s'", opts.InputFilename)
-	root, summary, err := validator.LoadAndValidate(opts.InputFilename)
+	validatorOpts, err := opts.Globals.ValidatorOptions()
+	if err != nil {
+		return err
+	}
+
+	root, summary, err := validator.LoadAndValidateWithOptions(opts.InputFilename, validatorOpts)
 	if err != nil {
 		return PrettyPrintErrors(err)
 	} else {
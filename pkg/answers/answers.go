@@ -0,0 +1,119 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package answers models DNS answers tagged with route map labels and the
+// order in which Pulsar emits them for a client.
+package answers
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v2"
+)
+
+// Answer is a DNS answer and the route map label that selects it.
+type Answer struct {
+	Answer string `yaml:"answer" json:"answer"`
+	Label  string `yaml:"label" json:"label"`
+}
+
+// File is the answers file, in YAML or JSON.
+type File struct {
+	Answers []Answer `yaml:"answers"`
+}
+
+// LoadFile reads the named answers file.
+func LoadFile(filename string) ([]Answer, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{}
+	if err = yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, fmt.Errorf("parsing answers file %s: %v", filename, err)
+	}
+
+	var allErrs error
+	for idx, a := range f.Answers {
+		if len(a.Answer) == 0 {
+			multierr.AppendInto(&allErrs, fmt.Errorf("answer at index %d is empty", idx))
+		}
+		if len(a.Label) == 0 {
+			multierr.AppendInto(&allErrs, fmt.Errorf("answer at index %d has no label", idx))
+		}
+	}
+
+	if allErrs != nil {
+		return nil, fmt.Errorf("answers file %s: %v", filename, allErrs)
+	}
+
+	return f.Answers, nil
+}
+
+// Order returns the answers a client in a map segment with the given labels
+// receives: first the answers for the first label, then those for the second
+// label, and so on. Answers with the same label keep their relative order.
+// Answers whose label is not in labels are dropped.
+func Order(answers []Answer, labels []string) (ordered []Answer, dropped []Answer) {
+	byLabel := map[string][]Answer{}
+	for _, a := range answers {
+		byLabel[a.Label] = append(byLabel[a.Label], a)
+	}
+
+	for _, lbl := range labels {
+		ordered = append(ordered, byLabel[lbl]...)
+		delete(byLabel, lbl)
+	}
+
+	for _, a := range answers {
+		if _, ok := byLabel[a.Label]; ok {
+			dropped = append(dropped, a)
+		}
+	}
+
+	return ordered, dropped
+}
+
+// Unmatched is what a client that is in no map segment receives.
+type Unmatched string
+
+const (
+	// UnmatchedAll passes all answers through in their original order.
+	UnmatchedAll Unmatched = "all"
+
+	// UnmatchedNone drops all answers.
+	UnmatchedNone Unmatched = "none"
+)
+
+// ParseUnmatched parses "all" or "none".
+func ParseUnmatched(s string) (Unmatched, error) {
+	switch u := Unmatched(s); u {
+	case UnmatchedAll, UnmatchedNone:
+		return u, nil
+	default:
+		return "", fmt.Errorf("invalid unmatched policy '%s'; must be all or none", s)
+	}
+}
+
+// Apply returns the answers a client outside of every map segment receives.
+func (u Unmatched) Apply(answers []Answer) (kept []Answer, dropped []Answer) {
+	if u == UnmatchedNone {
+		return nil, answers
+	}
+
+	return answers, nil
+}
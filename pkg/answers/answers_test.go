@@ -0,0 +1,76 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package answers
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Order(t *testing.T) {
+	answers := []Answer{
+		{Answer: "192.0.2.1", Label: "a"},
+		{Answer: "192.0.2.2", Label: "b"},
+		{Answer: "192.0.2.3", Label: "c"},
+		{Answer: "192.0.2.4", Label: "b"},
+		{Answer: "192.0.2.5", Label: "d"},
+	}
+
+	ordered, dropped := Order(answers, []string{"b", "c", "a"})
+	assert.Equal(t, []Answer{answers[1], answers[3], answers[2], answers[0]}, ordered)
+	assert.Equal(t, []Answer{answers[4]}, dropped)
+
+	ordered, dropped = Order(answers, []string{"x"})
+	assert.Empty(t, ordered)
+	assert.Equal(t, answers, dropped)
+
+	kept, dropped := UnmatchedAll.Apply(answers)
+	assert.Equal(t, answers, kept)
+	assert.Empty(t, dropped)
+
+	kept, dropped = UnmatchedNone.Apply(answers)
+	assert.Empty(t, kept)
+	assert.Equal(t, answers, dropped)
+}
+
+func Test_LoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlFile := filepath.Join(dir, "answers.yaml")
+	require.NoError(t, ioutil.WriteFile(yamlFile, []byte(
+		"answers:\n- answer: 192.0.2.1\n  label: hkg\n- answer: 2001:db8::1\n  label: syd\n"), 0644))
+
+	got, err := LoadFile(yamlFile)
+	require.NoError(t, err)
+	assert.Equal(t, []Answer{{"192.0.2.1", "hkg"}, {"2001:db8::1", "syd"}}, got)
+
+	jsonFile := filepath.Join(dir, "answers.json")
+	require.NoError(t, ioutil.WriteFile(jsonFile, []byte(
+		`{"answers": [{"answer": "192.0.2.1", "label": "hkg"}]}`), 0644))
+
+	got, err = LoadFile(jsonFile)
+	require.NoError(t, err)
+	assert.Equal(t, []Answer{{"192.0.2.1", "hkg"}}, got)
+
+	badFile := filepath.Join(dir, "bad.yaml")
+	require.NoError(t, ioutil.WriteFile(badFile, []byte("answers:\n- answer: 192.0.2.1\n"), 0644))
+
+	_, err = LoadFile(badFile)
+	assert.EqualError(t, err, "answers file "+badFile+": answer at index 0 has no label")
+}
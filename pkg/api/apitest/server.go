@@ -0,0 +1,384 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apitest provides an in-memory fake of NS1's route map REST API for
+// use in tests.
+//
+// The fake implements listing, creating, replacing and deleting route maps,
+// including the two-step upload where the API returns a URL to which the map
+// is then PUT. It is served under both "/" and "/v1" so it can stand in for
+// the default API base URL.
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations that can be made to fail with FailNext.
+const (
+	OpList    = "list"
+	OpCreate  = "create"
+	OpReplace = "replace"
+	OpDelete  = "delete"
+	OpUpload  = "upload"
+)
+
+// StatusPending is the status of a route map that has been created but not
+// yet uploaded.
+const StatusPending = "pending"
+
+// DefaultTransitions are the statuses a route map moves through after an
+// upload, one step per list request.
+var DefaultTransitions = []string{"processing", "ready"}
+
+// Routemap is the server's view of a route map. It is also the JSON payload
+// returned by the list endpoint.
+type Routemap struct {
+	Customer  int    `json:"customer"`
+	MapID     int    `json:"mapid"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Created   int64  `json:"created"`
+	Modified  int64  `json:"modified"`
+	ErrorCode string `json:"errorCode"`
+
+	content    []byte
+	transition int
+}
+
+type failure struct {
+	status int
+	body   string
+}
+
+// Server is the fake API. It is an http.Handler; serve it with
+// net/http/httptest or any http.Server. Its methods are safe for concurrent
+// use.
+type Server struct {
+	// APIKey, if not empty, must be given in the X-NSONE-Key header of every
+	// API request.
+	APIKey string
+
+	// Customer is the customer ID reported for all route maps.
+	Customer int
+
+	mu          sync.Mutex
+	maps        map[int]*Routemap
+	nextID      int
+	uploads     map[string]int // upload token -> mapid
+	nextToken   int
+	transitions []string
+	failures    map[string][]failure
+	requests    []string
+	now         func() time.Time
+}
+
+// NewServer creates an empty fake API that requires apiKey (if not empty).
+func NewServer(apiKey string) *Server {
+	return &Server{
+		APIKey:      apiKey,
+		Customer:    1,
+		maps:        map[int]*Routemap{},
+		nextID:      1,
+		uploads:     map[string]int{},
+		transitions: DefaultTransitions,
+		failures:    map[string][]failure{},
+		now:         time.Now,
+	}
+}
+
+// SetTransitions sets the statuses a route map moves through after each
+// upload. The first applies as soon as the upload completes, each later one
+// after a further list request. A status may carry an error code as
+// "status:code", e.g. "failed:INVALID_MAP".
+func (s *Server) SetTransitions(statuses ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.transitions = statuses
+}
+
+// FailNext makes the next request for op fail with the given HTTP status and
+// body. Calls queue up; each failure is used once.
+func (s *Server) FailNext(op string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[op] = append(s.failures[op], failure{status: status, body: body})
+}
+
+// AddRoutemap stores a route map directly, as if it had been created and
+// uploaded and had finished all status transitions. It returns the new mapid.
+func (s *Server) AddRoutemap(name string, status string, content []byte) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.create(name)
+	m.Status = status
+	m.content = content
+	m.transition = len(s.transitions)
+	return m.MapID
+}
+
+// Routemaps returns a copy of all route maps ordered by mapid.
+func (s *Server) Routemaps() []Routemap {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list()
+}
+
+// Content returns the last map uploaded to mapid.
+func (s *Server) Content(mapid int) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m, ok := s.maps[mapid]; ok {
+		return m.content, m.content != nil
+	}
+
+	return nil, false
+}
+
+// Requests returns every request received, as "METHOD /path".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Read the body before taking the lock so that a slow client cannot
+	// block requests made concurrently by other clients.
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+	s.requests = append(s.requests, r.Method+" "+path)
+
+	// Uploads go to a "presigned" URL and so are not authenticated.
+	if strings.HasPrefix(path, "/upload/") {
+		s.handleUpload(w, r, strings.TrimPrefix(path, "/upload/"), body)
+		return
+	}
+
+	if len(s.APIKey) > 0 && r.Header.Get("X-NSONE-Key") != s.APIKey {
+		writeError(w, http.StatusUnauthorized, "Authentication failed")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "pulsar" || parts[1] != "routemaps" {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && r.Method == "GET":
+		s.handleList(w)
+	case len(parts) == 3 && parts[2] == "create" && r.Method == "GET":
+		s.handleCreate(w, r)
+	case len(parts) == 4 && parts[3] == "replace" && r.Method == "GET":
+		s.handleReplace(w, r, parts[2])
+	case len(parts) == 3 && r.Method == "DELETE":
+		s.handleDelete(w, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) handleList(w http.ResponseWriter) {
+	if s.injectFailure(w, OpList) {
+		return
+	}
+
+	for _, m := range s.maps {
+		s.advance(m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.list())
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if s.injectFailure(w, OpCreate) {
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if len(name) == 0 {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	m := s.create(name)
+	s.writeUploadURL(w, r, m.MapID)
+}
+
+func (s *Server) handleReplace(w http.ResponseWriter, r *http.Request, id string) {
+	if s.injectFailure(w, OpReplace) {
+		return
+	}
+
+	m, ok := s.lookup(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "route map not found")
+		return
+	}
+
+	s.writeUploadURL(w, r, m.MapID)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, id string) {
+	if s.injectFailure(w, OpDelete) {
+		return
+	}
+
+	m, ok := s.lookup(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "route map not found")
+		return
+	}
+
+	delete(s.maps, m.MapID)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, token string, body []byte) {
+	if r.Method != "PUT" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if s.injectFailure(w, OpUpload) {
+		return
+	}
+
+	mapid, ok := s.uploads[token]
+	if !ok {
+		writeError(w, http.StatusForbidden, "invalid or expired upload URL")
+		return
+	}
+
+	// Upload URLs are single use.
+	delete(s.uploads, token)
+
+	m, ok := s.maps[mapid]
+	if !ok {
+		writeError(w, http.StatusNotFound, "route map not found")
+		return
+	}
+
+	m.content = body
+	m.Modified = s.now().Unix()
+	m.transition = 0
+	s.advance(m)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) create(name string) *Routemap {
+	now := s.now().Unix()
+	m := &Routemap{
+		Customer: s.Customer,
+		MapID:    s.nextID,
+		Name:     name,
+		Status:   StatusPending,
+		Created:  now,
+		Modified: now,
+	}
+
+	s.maps[m.MapID] = m
+	s.nextID++
+	return m
+}
+
+func (s *Server) lookup(id string) (*Routemap, bool) {
+	mapid, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, false
+	}
+
+	m, ok := s.maps[mapid]
+	return m, ok
+}
+
+func (s *Server) list() []Routemap {
+	all := make([]Routemap, 0, len(s.maps))
+	for _, m := range s.maps {
+		all = append(all, *m)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].MapID < all[j].MapID })
+	return all
+}
+
+// advance moves an uploaded route map to its next status, if any.
+func (s *Server) advance(m *Routemap) {
+	if m.content == nil || m.transition >= len(s.transitions) {
+		return
+	}
+
+	parts := strings.SplitN(s.transitions[m.transition], ":", 2)
+	m.Status = parts[0]
+	m.ErrorCode = ""
+	if len(parts) == 2 {
+		m.ErrorCode = parts[1]
+	}
+
+	m.transition++
+}
+
+func (s *Server) writeUploadURL(w http.ResponseWriter, r *http.Request, mapid int) {
+	token := strconv.Itoa(s.nextToken)
+	s.nextToken++
+	s.uploads[token] = mapid
+
+	fmt.Fprintf(w, "http://%s/upload/%s", r.Host, token)
+}
+
+func (s *Server) injectFailure(w http.ResponseWriter, op string) bool {
+	queue := s.failures[op]
+	if len(queue) == 0 {
+		return false
+	}
+
+	f := queue[0]
+	s.failures[op] = queue[1:]
+
+	writeError(w, f.status, f.body)
+	return true
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
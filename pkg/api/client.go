@@ -21,9 +21,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/ns1/pulsar-routemap/pkg/model"
+	"golang.org/x/term"
 )
 
 // Client defines the interactions with NS1's REST API.
@@ -49,6 +52,33 @@ type httpClient struct {
 	inst *http.Client
 }
 
+// ClientOptions are optional settings for the API client.
+type ClientOptions struct {
+	// Timeout limits each request, including reading the response body. Zero
+	// means no timeout.
+	Timeout time.Duration
+
+	// ProxyURL is the HTTP(S) proxy for all requests. When empty, the
+	// HTTPS_PROXY/HTTP_PROXY/NO_PROXY environment variables apply.
+	ProxyURL string
+
+	// CABundle is a PEM file of certificate authorities to trust in addition
+	// to the system's, e.g. for an intercepting proxy.
+	CABundle string
+
+	// ClientCert and ClientKey are PEM files for TLS client authentication.
+	// ClientKey may be omitted if the key is in ClientCert.
+	ClientCert string
+	ClientKey  string
+
+	// MinTLSVersion is the lowest TLS version accepted: "1.0" to "1.3".
+	MinTLSVersion string
+
+	// RoundTripper, if set, performs all HTTP requests. The proxy and TLS
+	// options above are then ignored.
+	RoundTripper http.RoundTripper
+}
+
 // NewClient creates a new API client.
 func NewClient(baseURL string, apiKey string) Client {
 	return &httpClient{
@@ -58,6 +88,24 @@ func NewClient(baseURL string, apiKey string) Client {
 	}
 }
 
+// NewClientWithOptions creates a new API client with the given options.
+func NewClientWithOptions(baseURL string, apiKey string, opts ClientOptions) (Client, error) {
+	return newHTTPClient(baseURL, apiKey, opts)
+}
+
+func newHTTPClient(baseURL string, apiKey string, opts ClientOptions) (*httpClient, error) {
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpClient{
+		apiKey:  apiKey,
+		baseURL: baseURL,
+		inst:    &http.Client{Timeout: opts.Timeout, Transport: transport},
+	}, nil
+}
+
 func (c *httpClient) ListRoutemaps() ([]byte, error) {
 	var (
 		req  *http.Request
@@ -171,7 +219,12 @@ func (c *httpClient) uploadMap(root *model.RoutemapRoot, startUploadReq *http.Re
 		return fmt.Errorf("starting map upload: %v", err)
 	}
 
-	body := bytes.NewReader(root.Raw)
+	var tty io.Writer
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		tty = os.Stderr
+	}
+
+	body := newProgressReader(bytes.NewReader(root.Raw), int64(len(root.Raw)), tty)
 
 	// Note: we aren't issuing an API request here; it's a fully-qualified URL.
 	var req *http.Request
@@ -179,13 +232,26 @@ func (c *httpClient) uploadMap(root *model.RoutemapRoot, startUploadReq *http.Re
 		return fmt.Errorf("creating API request: %v", err)
 	}
 
+	// The progress reader hides the length of the body from net/http.
+	req.ContentLength = int64(len(root.Raw))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(root.Raw)), nil
+	}
+
 	var resp *http.Response
-	if resp, err = c.inst.Do(req); err != nil {
+	resp, err = c.inst.Do(req)
+	body.finish()
+
+	if err != nil {
 		return fmt.Errorf("uploading routemap: %v", err)
 	} else if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("transferring routemap: %v", notOKToError(resp))
 	}
 
+	// Drain the body so the connection can be reused.
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
 	return nil
 }
 
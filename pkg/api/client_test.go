@@ -0,0 +1,155 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ns1/pulsar-routemap/pkg/api/apitest"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMap = `{"meta":{"version":1},"map":[{"networks":["10.0.0.0/24"],"labels":["syd"]}]}`
+
+func newTestServer(t *testing.T) (*apitest.Server, Client) {
+	srv, baseURL := newTestServerURL(t)
+	return srv, NewClient(baseURL, "test-key")
+}
+
+// newTestServerURL starts a fake API server and returns its base URL.
+func newTestServerURL(t *testing.T) (*apitest.Server, string) {
+	srv := apitest.NewServer("test-key")
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	return srv, ts.URL + "/v1"
+}
+
+func listPayloads(t *testing.T, c Client) []RoutemapPayload {
+	body, err := c.ListRoutemaps()
+	require.NoError(t, err)
+
+	var rmaps []RoutemapPayload
+	require.NoError(t, json.Unmarshal(body, &rmaps))
+	return rmaps
+}
+
+func Test_createAndReplace(t *testing.T) {
+	srv, c := newTestServer(t)
+	root := &model.RoutemapRoot{Raw: []byte(testMap)}
+
+	require.NoError(t, c.CreateRoutemap(root, "syd map"))
+
+	rmaps := listPayloads(t, c)
+	require.Len(t, rmaps, 1)
+	assert.Equal(t, "syd map", rmaps[0].Name)
+
+	content, ok := srv.Content(rmaps[0].MapID)
+	assert.True(t, ok)
+	assert.Equal(t, testMap, string(content))
+
+	replacement := &model.RoutemapRoot{Raw: []byte(`{"meta":{"version":1},"map":[]}`)}
+	require.NoError(t, c.ReplaceRoutemap(replacement, rmaps[0].MapID))
+
+	content, _ = srv.Content(rmaps[0].MapID)
+	assert.Equal(t, replacement.Raw, content)
+}
+
+func Test_replaceMissing(t *testing.T) {
+	_, c := newTestServer(t)
+	root := &model.RoutemapRoot{Raw: []byte(testMap)}
+
+	err := c.ReplaceRoutemap(root, 42)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
+
+func Test_delete(t *testing.T) {
+	srv, c := newTestServer(t)
+	mapid := srv.AddRoutemap("old", "ready", []byte(testMap))
+
+	require.NoError(t, c.DeleteRoutemap(mapid))
+	assert.Empty(t, srv.Routemaps())
+
+	assert.Error(t, c.DeleteRoutemap(mapid))
+}
+
+func Test_statusTransitions(t *testing.T) {
+	srv, c := newTestServer(t)
+	srv.SetTransitions("processing", "failed:INVALID_MAP")
+
+	require.NoError(t, c.CreateRoutemap(&model.RoutemapRoot{Raw: []byte(testMap)}, "m"))
+
+	// The first transition applies on upload and the next on a list request.
+	assert.Equal(t, "processing", srv.Routemaps()[0].Status)
+
+	rmaps := listPayloads(t, c)
+	assert.Equal(t, "failed", rmaps[0].Status)
+	assert.Equal(t, "INVALID_MAP", rmaps[0].ErrorCode)
+}
+
+func Test_injectedFailures(t *testing.T) {
+	srv, c := newTestServer(t)
+	root := &model.RoutemapRoot{Raw: []byte(testMap)}
+
+	srv.FailNext(apitest.OpList, http.StatusInternalServerError, "boom")
+	_, err := c.ListRoutemaps()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "boom")
+
+	srv.FailNext(apitest.OpUpload, http.StatusForbidden, "expired")
+	err = c.CreateRoutemap(root, "m")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "transferring routemap")
+
+	// Failures are used once.
+	_, err = c.ListRoutemaps()
+	assert.NoError(t, err)
+}
+
+func Test_authentication(t *testing.T) {
+	srv := apitest.NewServer("test-key")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "wrong-key").ListRoutemaps()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
+
+func Test_dryRunClient(t *testing.T) {
+	srv, baseURL := newTestServerURL(t)
+
+	out := &bytes.Buffer{}
+	c, err := NewDryRunClient(baseURL, "test-key", ClientOptions{}, out)
+	require.NoError(t, err)
+	mapid := srv.AddRoutemap("m", "ready", []byte(testMap))
+
+	_, err = c.ListRoutemaps()
+	assert.NoError(t, err)
+	assert.NoError(t, c.CreateRoutemap(&model.RoutemapRoot{Raw: []byte(testMap)}, "new"))
+	assert.NoError(t, c.ReplaceRoutemap(&model.RoutemapRoot{Raw: []byte(testMap)}, mapid))
+	assert.NoError(t, c.DeleteRoutemap(mapid))
+
+	assert.Equal(t, []string{"GET /pulsar/routemaps"}, srv.Requests())
+	assert.Contains(t, out.String(), "GET "+baseURL+"/pulsar/routemaps/create?name=new")
+	assert.Contains(t, out.String(), "DELETE "+baseURL+"/pulsar/routemaps/1")
+}
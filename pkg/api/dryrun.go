@@ -0,0 +1,90 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+)
+
+// dryRunClient issues read-only requests but only describes mutating ones.
+type dryRunClient struct {
+	inst *httpClient
+	w    io.Writer
+}
+
+// NewDryRunClient creates an API client that lists route maps as normal but
+// writes a description of every mutating request to w instead of issuing it.
+func NewDryRunClient(baseURL string, apiKey string, opts ClientOptions, w io.Writer) (Client, error) {
+	inst, err := newHTTPClient(baseURL, apiKey, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dryRunClient{inst: inst, w: w}, nil
+}
+
+func (c *dryRunClient) ListRoutemaps() ([]byte, error) {
+	return c.inst.ListRoutemaps()
+}
+
+func (c *dryRunClient) CreateRoutemap(root *model.RoutemapRoot, name string) error {
+	req, err := c.inst.newRequest("GET", "/pulsar/routemaps/create")
+	if err != nil {
+		return fmt.Errorf("creating API request: %v", err)
+	}
+
+	q := url.Values{}
+	q.Add("name", name)
+	req.URL.RawQuery = q.Encode()
+
+	c.describeUpload(root, req)
+	return nil
+}
+
+func (c *dryRunClient) ReplaceRoutemap(root *model.RoutemapRoot, mapid int) error {
+	req, err := c.inst.newRequest("GET", fmt.Sprintf("/pulsar/routemaps/%d/replace", mapid))
+	if err != nil {
+		return fmt.Errorf("creating API request: %v", err)
+	}
+
+	c.describeUpload(root, req)
+	return nil
+}
+
+func (c *dryRunClient) DeleteRoutemap(mapid int) error {
+	req, err := c.inst.newRequest("DELETE", fmt.Sprintf("/pulsar/routemaps/%d", mapid))
+	if err != nil {
+		return fmt.Errorf("creating API request: %v", err)
+	}
+
+	c.describe(req.Method, req.URL.String())
+	return nil
+}
+
+func (c *dryRunClient) describeUpload(root *model.RoutemapRoot, startUploadReq *http.Request) {
+	c.describe(startUploadReq.Method, startUploadReq.URL.String())
+	c.describe("PUT", fmt.Sprintf("<upload URL from previous response> (%d bytes, sha1 %s)",
+		root.SizeInBytes, hex.EncodeToString(root.SHA1)))
+}
+
+func (c *dryRunClient) describe(method string, target string) {
+	fmt.Fprintf(c.w, "[dry-run] %s %s\n", method, target)
+}
@@ -18,30 +18,31 @@ import "time"
 
 // RoutemapPayload is a catch-all struct for responses from the routemap API.
 type RoutemapPayload struct {
-	Customer  int    `json:"customer"`
-	MapID     int    `json:"mapid"`
-	Name      string `json:"name"`
-	Status    string `json:"status"`
-	Created   int64  `json:"created"`
-	Modified  int64  `json:"modified"`
-	ErrorCode string `json:"errorCode"`
+	Customer  int    `json:"customer" yaml:"customer"`
+	MapID     int    `json:"mapid" yaml:"mapid"`
+	Name      string `json:"name" yaml:"name"`
+	Status    string `json:"status" yaml:"status"`
+	Created   int64  `json:"created" yaml:"created"`
+	Modified  int64  `json:"modified" yaml:"modified"`
+	ErrorCode string `json:"errorCode" yaml:"errorCode"`
 }
 
-// CreatedString returns a formatted date-time string when the routemap was created.
+// CreatedString returns a formatted date-time string (RFC3339, UTC) when the
+// routemap was created.
 func (r *RoutemapPayload) CreatedString() string {
-	if r.Created < 1 {
-		return ""
-	}
-
-	return time.Unix(r.Created, 0).String()
+	return formatTimestamp(r.Created)
 }
 
-// ModifiedString returns a formatted date-time string when the routemap was last
-// modified.
+// ModifiedString returns a formatted date-time string (RFC3339, UTC) when the
+// routemap was last modified.
 func (r *RoutemapPayload) ModifiedString() string {
-	if r.Modified < 1 {
+	return formatTimestamp(r.Modified)
+}
+
+func formatTimestamp(epochSecs int64) string {
+	if epochSecs < 1 {
 		return ""
 	}
 
-	return time.Unix(r.Modified, 0).String()
+	return time.Unix(epochSecs, 0).UTC().Format(time.RFC3339)
 }
@@ -0,0 +1,150 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+)
+
+const (
+	// How often progress is redrawn on a terminal.
+	ttyProgressInterval = 200 * time.Millisecond
+
+	// How often progress is logged when not on a terminal.
+	logProgressInterval = 10 * time.Second
+)
+
+// progressReader reports how much of an upload body has been read. On a
+// terminal it redraws a single status line; otherwise it logs periodically.
+//
+// The transport may still be reading the body when the request returns, so
+// the counters are guarded by mu.
+type progressReader struct {
+	r     io.Reader
+	total int64
+
+	mu   sync.Mutex
+	sent int64
+
+	tty      io.Writer // nil when not on a terminal.
+	interval time.Duration
+
+	start      time.Time
+	lastReport time.Time
+	now        func() time.Time
+}
+
+func newProgressReader(r io.Reader, total int64, tty io.Writer) *progressReader {
+	p := &progressReader{
+		r:        r,
+		total:    total,
+		tty:      tty,
+		interval: logProgressInterval,
+		now:      time.Now,
+	}
+
+	if tty != nil {
+		p.interval = ttyProgressInterval
+	}
+
+	p.start = p.now()
+	p.lastReport = p.start
+	return p
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sent += int64(n)
+
+	if now := p.now(); now.Sub(p.lastReport) >= p.interval {
+		p.lastReport = now
+		p.report(now)
+	}
+
+	return n, err
+}
+
+func (p *progressReader) report(now time.Time) {
+	elapsed := now.Sub(p.start)
+	rate := throughput(p.sent, elapsed)
+
+	pct := 100.0
+	if p.total > 0 {
+		pct = float64(p.sent) * 100 / float64(p.total)
+	}
+
+	eta := "unknown"
+	if rate > 0 {
+		remaining := time.Duration(float64(p.total-p.sent)/rate) * time.Second
+		eta = remaining.Round(time.Second).String()
+	}
+
+	status := fmt.Sprintf("uploaded %s of %s (%.1f%%) at %s/s, ETA %s",
+		humanBytes(p.sent), humanBytes(p.total), pct, humanBytes(int64(rate)), eta)
+
+	if p.tty != nil {
+		// Pad to overwrite any longer previous line.
+		fmt.Fprintf(p.tty, "\r%-72s", status)
+	} else {
+		lg.Infof("%s", status)
+	}
+}
+
+// finish clears the terminal status line and logs the overall transfer stats.
+func (p *progressReader) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tty != nil {
+		fmt.Fprintf(p.tty, "\r%72s\r", "")
+	}
+
+	elapsed := p.now().Sub(p.start)
+	lg.Infof("uploaded %s in %s (average %s/s)",
+		humanBytes(p.sent), elapsed.Round(time.Millisecond), humanBytes(int64(throughput(p.sent, elapsed))))
+}
+
+// throughput is in bytes per second.
+func throughput(n int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(n) / elapsed.Seconds()
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
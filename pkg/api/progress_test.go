@@ -0,0 +1,55 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_progressReader(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 4096)
+	tty := &bytes.Buffer{}
+
+	p := newProgressReader(bytes.NewReader(data), int64(len(data)), tty)
+
+	// Each read advances the clock by one second.
+	clock := p.start
+	p.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	buf := make([]byte, 1024)
+	n, err := p.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 1024, n)
+	assert.Contains(t, tty.String(), "uploaded 1.0 KiB of 4.0 KiB (25.0%) at 1.0 KiB/s, ETA 3s")
+
+	rest, err := ioutil.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Len(t, rest, 3072)
+	assert.Equal(t, int64(len(data)), p.sent)
+}
+
+func Test_humanBytes(t *testing.T) {
+	assert.Equal(t, "512 B", humanBytes(512))
+	assert.Equal(t, "1.5 KiB", humanBytes(1536))
+	assert.Equal(t, "450.0 MiB", humanBytes(450*1024*1024))
+}
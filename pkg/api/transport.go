@@ -0,0 +1,126 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTransport builds the transport for the API client from opts. A
+// RoundTripper given in opts is used as is.
+func newTransport(opts ClientOptions) (http.RoundTripper, error) {
+	if opts.RoundTripper != nil {
+		return opts.RoundTripper, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if len(opts.ProxyURL) > 0 {
+		proxy, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %v", err)
+		}
+		lg.Debugf("using proxy %s", redactURL(proxy))
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// redactURL returns u as a string with any password replaced.
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+
+	if _, ok := u.User.Password(); !ok {
+		return u.String()
+	}
+
+	redacted := *u
+	redacted.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return redacted.String()
+}
+
+func newTLSConfig(opts ClientOptions) (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	if len(opts.MinTLSVersion) > 0 {
+		v, ok := tlsVersions[opts.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS version '%s' (expected 1.0, 1.1, 1.2 or 1.3)", opts.MinTLSVersion)
+		}
+		cfg.MinVersion = v
+	}
+
+	if len(opts.CABundle) > 0 {
+		// Extend rather than replace the system roots so that the API remains
+		// reachable when the proxy is bypassed.
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := ioutil.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %v", err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CABundle)
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if len(opts.ClientCert) > 0 {
+		// The key may be in the same PEM file as the certificate.
+		keyFile := opts.ClientKey
+		if len(keyFile) == 0 {
+			keyFile = opts.ClientCert
+		}
+
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	} else if len(opts.ClientKey) > 0 {
+		return nil, fmt.Errorf("a client key was given without a client certificate")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,335 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package generate writes synthetic route maps for load and scale testing.
+package generate
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+)
+
+// Kinds of validation errors that may be injected into a generated map.
+const (
+	ErrUnmasked       = "unmasked"        // network address with host bits set
+	ErrLongPrefix     = "long-prefix"     // prefix longer than the maximum allowed
+	ErrBadCIDR        = "bad-cidr"        // unparsable network
+	ErrDuplicateLabel = "duplicate-label" // same label twice, differing in case
+	ErrNonASCIILabel  = "non-ascii-label" // label with non-ASCII characters
+	ErrEmptyLabels    = "empty-labels"    // segment without labels
+	ErrEmptyNetworks  = "empty-networks"  // segment without networks
+)
+
+// ErrorKinds lists the kinds of errors that may be injected.
+var ErrorKinds = []string{
+	ErrUnmasked,
+	ErrLongPrefix,
+	ErrBadCIDR,
+	ErrDuplicateLabel,
+	ErrNonASCIILabel,
+	ErrEmptyLabels,
+	ErrEmptyNetworks,
+}
+
+// Options describe the map to generate.
+type Options struct {
+	Segments           int
+	NetworksPerSegment int
+
+	// V6Ratio is the fraction of networks, from 0 to 1, that are IPv6.
+	V6Ratio float64
+
+	// Labels is the number of distinct labels. Each segment has from one to
+	// three of them.
+	Labels int
+
+	// Seed makes the output reproducible: the same options always produce
+	// the same map.
+	Seed int64
+
+	// Inject is the number of segments with each kind of error.
+	Inject map[string]int
+}
+
+// Validate checks that the options describe a map that can be generated.
+func (o *Options) Validate() error {
+	switch {
+	case o.Segments < 1:
+		return fmt.Errorf("segments must be at least 1")
+	case o.NetworksPerSegment < 1:
+		return fmt.Errorf("networks per segment must be at least 1")
+	case o.V6Ratio < 0 || o.V6Ratio > 1:
+		return fmt.Errorf("v6 ratio must be between 0 and 1")
+	case o.Labels < 1:
+		return fmt.Errorf("labels must be at least 1")
+	}
+
+	total := 0
+	for kind, n := range o.Inject {
+		if !isErrorKind(kind) {
+			return fmt.Errorf("unknown error kind '%s'; must be one of: %s", kind, strings.Join(ErrorKinds, ", "))
+		}
+		if n < 0 {
+			return fmt.Errorf("number of %s errors must not be negative", kind)
+		}
+		total += n
+	}
+
+	if total > o.Segments {
+		return fmt.Errorf("cannot inject %d errors into %d segments", total, o.Segments)
+	}
+
+	return nil
+}
+
+func isErrorKind(kind string) bool {
+	for _, k := range ErrorKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stats describe a generated map.
+type Stats struct {
+	Segments    int
+	Networks    int
+	SizeInBytes int64
+	SHA1        []byte
+}
+
+// allocator hands out non-overlapping, properly masked networks in address
+// order.
+type allocator struct {
+	v4 uint64 // next free IPv4 address
+	v6 uint64 // next free IPv6 /64, as the upper 64 bits of the address
+}
+
+const (
+	v4Start = 1 << 24    // 1.0.0.0
+	v4End   = 224 << 24  // 224.0.0.0, start of multicast
+	v6Start = 0x24 << 56 // 2400::
+	v6End   = 0x40 << 56 // 4000::, end of global unicast
+)
+
+func newAllocator() *allocator {
+	return &allocator{v4: v4Start, v6: v6Start}
+}
+
+// alignUp rounds cursor up to a multiple of size, a power of two.
+func alignUp(cursor, size uint64) uint64 {
+	return (cursor + size - 1) &^ (size - 1)
+}
+
+func (a *allocator) nextV4(ones int) (*net.IPNet, error) {
+	size := uint64(1) << uint(32-ones)
+	start := alignUp(a.v4, size)
+	if start+size > v4End {
+		return nil, fmt.Errorf("IPv4 address space exhausted; use fewer networks or a higher v6 ratio")
+	}
+	a.v4 = start + size
+
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, uint32(start))
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(ones, 32)}, nil
+}
+
+func (a *allocator) nextV6(ones int) (*net.IPNet, error) {
+	size := uint64(1) << uint(64-ones)
+	start := alignUp(a.v6, size)
+	if start+size > v6End {
+		return nil, fmt.Errorf("IPv6 address space exhausted")
+	}
+	a.v6 = start + size
+
+	ip := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(ip, start)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(ones, 128)}, nil
+}
+
+// Prefix lengths are weighted towards the most common ones in real maps.
+var (
+	v4PrefixLengths = []int{20, 22, 23, 24, 24, 24, 24, 25, 26}
+	v6PrefixLengths = []int{32, 40, 44, 48, 48, 48, 56, 64}
+)
+
+type generator struct {
+	opts   Options
+	rng    *rand.Rand
+	alloc  *allocator
+	labels []string
+	errors map[int]string // Segment index to injected error kind.
+}
+
+func newGenerator(opts Options) *generator {
+	g := &generator{
+		opts:   opts,
+		rng:    rand.New(rand.NewSource(opts.Seed)),
+		alloc:  newAllocator(),
+		errors: map[int]string{},
+	}
+
+	for i := 0; i < opts.Labels; i++ {
+		g.labels = append(g.labels, fmt.Sprintf("label-%d", i))
+	}
+
+	// Place each error in a different segment, in a fixed order so the result
+	// only depends on the seed.
+	var kinds []string
+	for kind := range opts.Inject {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	perm := g.rng.Perm(opts.Segments)
+	for _, kind := range kinds {
+		for i := 0; i < opts.Inject[kind]; i++ {
+			g.errors[perm[0]] = kind
+			perm = perm[1:]
+		}
+	}
+
+	return g
+}
+
+func (g *generator) network() (*net.IPNet, error) {
+	if g.rng.Float64() < g.opts.V6Ratio {
+		return g.alloc.nextV6(v6PrefixLengths[g.rng.Intn(len(v6PrefixLengths))])
+	}
+
+	return g.alloc.nextV4(v4PrefixLengths[g.rng.Intn(len(v4PrefixLengths))])
+}
+
+type segment struct {
+	Networks []string `json:"networks"`
+	Labels   []string `json:"labels"`
+}
+
+func (g *generator) segment(idx int) (*segment, error) {
+	s := &segment{Networks: []string{}, Labels: []string{}}
+
+	for i := 0; i < g.opts.NetworksPerSegment; i++ {
+		ipnet, err := g.network()
+		if err != nil {
+			return nil, err
+		}
+		s.Networks = append(s.Networks, ipnet.String())
+	}
+
+	max := 3
+	if len(g.labels) < max {
+		max = len(g.labels)
+	}
+	for _, i := range g.rng.Perm(len(g.labels))[:1+g.rng.Intn(max)] {
+		s.Labels = append(s.Labels, g.labels[i])
+	}
+
+	switch g.errors[idx] {
+	case ErrUnmasked:
+		_, ipnet, _ := net.ParseCIDR(s.Networks[0])
+		ip := append(net.IP{}, ipnet.IP...)
+		ip[len(ip)-1] |= 1
+		ones, _ := ipnet.Mask.Size()
+		s.Networks[0] = fmt.Sprintf("%s/%d", ip, ones)
+	case ErrLongPrefix:
+		ipnet, err := g.alloc.nextV4(model.MaxNetworkBitsV4 + 2)
+		if err != nil {
+			return nil, err
+		}
+		s.Networks[0] = ipnet.String()
+	case ErrBadCIDR:
+		s.Networks[0] = "256.0.0.0/24"
+	case ErrDuplicateLabel:
+		s.Labels = append(s.Labels, strings.ToUpper(s.Labels[0]))
+	case ErrNonASCIILabel:
+		s.Labels[0] += "-é"
+	case ErrEmptyLabels:
+		s.Labels = []string{}
+	case ErrEmptyNetworks:
+		s.Networks = []string{}
+	}
+
+	return s, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Write streams a generated route map to w, one segment at a time.
+func Write(w io.Writer, opts Options) (Stats, error) {
+	var stats Stats
+
+	if err := opts.Validate(); err != nil {
+		return stats, err
+	}
+
+	hash := sha1.New()
+	counter := &countingWriter{w: io.MultiWriter(w, hash)}
+	buf := bufio.NewWriterSize(counter, 1<<20)
+	enc := json.NewEncoder(buf)
+
+	g := newGenerator(opts)
+
+	buf.WriteString(`{"meta":{"version":1},"map":[` + "\n")
+
+	for idx := 0; idx < opts.Segments; idx++ {
+		s, err := g.segment(idx)
+		if err != nil {
+			return stats, err
+		}
+
+		if idx > 0 {
+			buf.WriteString(",")
+		}
+
+		// Encode ends each segment with a newline.
+		if err = enc.Encode(s); err != nil {
+			return stats, err
+		}
+
+		stats.Segments++
+		stats.Networks += len(s.Networks)
+	}
+
+	buf.WriteString("]}\n")
+
+	if err := buf.Flush(); err != nil {
+		return stats, err
+	}
+
+	stats.SizeInBytes = counter.n
+	stats.SHA1 = hash.Sum(nil)
+	return stats, nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generate
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"math/big"
+	"net"
+	"sort"
+	"testing"
+	"testing/quick"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/multierr"
+)
+
+func generate(t *testing.T, opts Options) ([]byte, Stats) {
+	buf := &bytes.Buffer{}
+	stats, err := Write(buf, opts)
+	require.NoError(t, err)
+	return buf.Bytes(), stats
+}
+
+// assertNoOverlap checks that no two networks share an address.
+func assertNoOverlap(t *testing.T, root *model.RoutemapRoot) {
+	type span struct{ start, end *big.Int }
+	var spans []span
+
+	for _, m := range root.Routemap {
+		for _, n := range m.Networks {
+			_, ipnet, err := net.ParseCIDR(n)
+			require.NoError(t, err)
+
+			ones, bits := ipnet.Mask.Size()
+			start := new(big.Int).SetBytes(ipnet.IP.To16())
+			size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+			spans = append(spans, span{start, new(big.Int).Add(start, size)})
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start.Cmp(spans[j].start) < 0 })
+
+	for i := 1; i < len(spans); i++ {
+		assert.True(t, spans[i].start.Cmp(spans[i-1].end) >= 0, "networks overlap at %s", spans[i].start)
+	}
+}
+
+func Test_Write(t *testing.T) {
+	opts := Options{Segments: 500, NetworksPerSegment: 4, V6Ratio: 0.3, Labels: 20, Seed: 7}
+
+	data, stats := generate(t, opts)
+	assert.Equal(t, 500, stats.Segments)
+	assert.Equal(t, 2000, stats.Networks)
+	assert.Equal(t, int64(len(data)), stats.SizeInBytes)
+
+	sum := sha1.Sum(data)
+	assert.Equal(t, sum[:], stats.SHA1)
+
+	root, err := model.LoadRoutemap(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, stats.SHA1, root.SHA1)
+
+	summary, err := validator.ValidateRoot(root, validator.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2000, summary.NumNetworks)
+	assert.InDelta(t, 600, summary.NumIPv6, 100)
+	assertNoOverlap(t, root)
+
+	// The same seed produces the same map; another seed does not.
+	again, _ := generate(t, opts)
+	assert.Equal(t, data, again)
+
+	opts.Seed = 8
+	other, _ := generate(t, opts)
+	assert.NotEqual(t, data, other)
+}
+
+// Whatever the options, the generated map validates and has the SHA1 of its
+// bytes.
+func Test_Write_alwaysValid(t *testing.T) {
+	valid := func(seed int64, segments, networks, labels, v6Pct uint8) bool {
+		opts := Options{
+			Segments:           1 + int(segments),
+			NetworksPerSegment: 1 + int(networks%16),
+			Labels:             1 + int(labels%10),
+			V6Ratio:            float64(v6Pct%101) / 100,
+			Seed:               seed,
+		}
+
+		buf := &bytes.Buffer{}
+		stats, err := Write(buf, opts)
+		if err != nil {
+			return false
+		}
+
+		root, err := model.LoadRoutemap(buf)
+		if err != nil || !bytes.Equal(root.SHA1, stats.SHA1) {
+			return false
+		}
+
+		_, err = validator.ValidateRoot(root, validator.Options{})
+		return err == nil
+	}
+
+	require.NoError(t, quick.Check(valid, &quick.Config{MaxCount: 50}))
+}
+
+func Test_Write_injectErrors(t *testing.T) {
+	inject := map[string]int{}
+	for _, kind := range ErrorKinds {
+		inject[kind] = 2
+	}
+
+	data, _ := generate(t, Options{Segments: 50, NetworksPerSegment: 2, Labels: 5, Seed: 1, Inject: inject})
+
+	root, err := model.LoadRoutemap(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	_, err = validator.ValidateRoot(root, validator.Options{})
+	assert.Len(t, multierr.Errors(err), 2*len(ErrorKinds))
+}
+
+func Test_Options_Validate(t *testing.T) {
+	base := Options{Segments: 2, NetworksPerSegment: 1, Labels: 1}
+	assert.NoError(t, base.Validate())
+
+	bad := base
+	bad.V6Ratio = 1.5
+	assert.EqualError(t, bad.Validate(), "v6 ratio must be between 0 and 1")
+
+	bad = base
+	bad.Inject = map[string]int{"typo": 1}
+	assert.Error(t, bad.Validate())
+
+	bad = base
+	bad.Inject = map[string]int{ErrBadCIDR: 2, ErrEmptyLabels: 1}
+	assert.EqualError(t, bad.Validate(), "cannot inject 3 errors into 2 segments")
+
+}
+
+func Test_allocator(t *testing.T) {
+	a := newAllocator()
+
+	ipnet, err := a.nextV4(26)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0.0/26", ipnet.String())
+
+	// Aligned to the next /24.
+	ipnet, err = a.nextV4(24)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.1.0/24", ipnet.String())
+
+	ipnet, err = a.nextV6(48)
+	require.NoError(t, err)
+	assert.Equal(t, "2400::/48", ipnet.String())
+
+	a.v4 = v4End - 256
+	_, err = a.nextV4(24)
+	require.NoError(t, err)
+	_, err = a.nextV4(24)
+	assert.EqualError(t, err, "IPv4 address space exhausted; use fewer networks or a higher v6 ratio")
+}
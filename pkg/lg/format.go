@@ -0,0 +1,120 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+var levelNames = map[int]string{
+	LoggingOff: "",
+	LevelError: "ERROR",
+	LevelWarn:  "WARN",
+	LevelInfo:  "INFO",
+	LevelDebug: "DEBUG",
+	LevelTrace: "TRACE",
+}
+
+// textLogger writes entries as "[LEVEL] message key=value ...".
+type textLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextLogger creates a Logger that writes human-readable lines to w.
+func NewTextLogger(w io.Writer) Logger {
+	return &textLogger{w: w}
+}
+
+func (l *textLogger) Log(e Entry) {
+	buf := &bytes.Buffer{}
+
+	if name := levelNames[e.Level]; len(name) > 0 {
+		fmt.Fprintf(buf, "[%s] ", name)
+	}
+
+	buf.WriteString(e.Message)
+
+	for _, f := range e.Fields {
+		fmt.Fprintf(buf, " %s=%v", f.Key, f.Value)
+	}
+
+	buf.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.w.Write(buf.Bytes())
+}
+
+// jsonLogger writes entries as one JSON object per line.
+type jsonLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLogger creates a Logger that writes one JSON object per line to w,
+// with "time", "level" and "msg" keys followed by the entry's fields.
+func NewJSONLogger(w io.Writer) Logger {
+	return &jsonLogger{w: w}
+}
+
+func (l *jsonLogger) Log(e Entry) {
+	level := strings.ToLower(levelNames[e.Level])
+	if len(level) == 0 {
+		level = "info"
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"time":`)
+	writeJSON(buf, e.Time.UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSON(buf, level)
+	buf.WriteString(`,"msg":`)
+	writeJSON(buf, e.Message)
+
+	for _, f := range e.Fields {
+		buf.WriteByte(',')
+		writeJSON(buf, f.Key)
+		buf.WriteByte(':')
+		writeJSON(buf, f.Value)
+	}
+
+	buf.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.w.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%v", v))
+	}
+
+	buf.Write(b)
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTime = time.Date(2020, 5, 1, 12, 30, 0, 0, time.UTC)
+
+func Test_textLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewTextLogger(buf)
+
+	l.Log(Entry{Time: testTime, Level: LevelInfo, Message: "uploading"})
+	l.Log(Entry{Time: testTime, Level: LoggingOff, Message: "deleted route map 5",
+		Fields: []Field{F("mapid", 5)}})
+
+	assert.Equal(t, "[INFO] uploading\ndeleted route map 5 mapid=5\n", buf.String())
+}
+
+func Test_jsonLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewJSONLogger(buf)
+
+	l.Log(Entry{Time: testTime, Level: LevelWarn, Message: "upload failed",
+		Fields: []Field{F("mapid", 5), F("sha1", "abc"), F("err", errors.New("boom"))}})
+
+	assert.Equal(t,
+		`{"time":"2020-05-01T12:30:00Z","level":"warn","msg":"upload failed","mapid":5,"sha1":"abc","err":"boom"}`+"\n",
+		buf.String())
+
+	var v map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &v))
+}
+
+type recorder struct {
+	entries []Entry
+}
+
+func (r *recorder) Log(e Entry) {
+	r.entries = append(r.entries, e)
+}
+
+func Test_SetLogger(t *testing.T) {
+	r := &recorder{}
+	SetLogger(r)
+	SetLevel(LevelInfo)
+	defer func() {
+		SetLogger(NewTextLogger(os.Stderr))
+		SetLevel(LevelError)
+	}()
+
+	Debugf("not shown")
+	With(F("mapid", 1)).With(F("segment", 2)).Infof("hello %s", "there")
+	Printf("always")
+
+	require.Len(t, r.entries, 2)
+	assert.Equal(t, LevelInfo, r.entries[0].Level)
+	assert.Equal(t, "hello there", r.entries[0].Message)
+	assert.Equal(t, []Field{F("mapid", 1), F("segment", 2)}, r.entries[0].Fields)
+	assert.Equal(t, LoggingOff, r.entries[1].Level)
+}
@@ -16,8 +16,10 @@ package lg
 
 import (
 	"fmt"
-	"log"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -29,70 +31,152 @@ const (
 	LevelTrace
 )
 
+// Field is a key/value pair attached to a log entry, e.g. the mapid a
+// message relates to.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Entry is a single log message.
+type Entry struct {
+	Time    time.Time
+	Level   int // LoggingOff for output from Printf, which ignores the log level.
+	Message string
+	Fields  []Field
+}
+
+// Logger writes log entries. Library users may implement it to send this
+// package's output to their own logging system; see SetLogger.
+type Logger interface {
+	Log(e Entry)
+}
+
 var (
-	logger       *log.Logger
-	rootPriority int
+	mu     sync.RWMutex
+	logger Logger
+
+	// rootPriority is read atomically as it is checked on hot paths.
+	rootPriority int32
 )
 
+// SetLogger replaces the destination of all log output. The default writes
+// text to STDERR.
+func SetLogger(l Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	logger = l
+}
+
 func SetLevel(priority int) error {
 	if priority < LoggingOff || priority > LevelTrace {
 		return fmt.Errorf("invalid priority level %d", priority)
 	}
 
-	rootPriority = priority
+	atomic.StoreInt32(&rootPriority, int32(priority))
 	return nil
 }
 
 func EnabledFor(priority int) bool {
-	return priority <= rootPriority
+	return priority <= int(atomic.LoadInt32(&rootPriority))
 }
 
-func Tracef(format string, v ...interface{}) {
-	if EnabledFor(LevelTrace) {
-		f := "[TRACE] " + format
-		logger.Printf(f, v...)
+// Context logs messages with a fixed set of fields.
+type Context struct {
+	fields []Field
+}
+
+// With returns a Context that adds fields to every message.
+func With(fields ...Field) *Context {
+	return &Context{fields: fields}
+}
+
+// With returns a new Context with fields in addition to those of c.
+func (c *Context) With(fields ...Field) *Context {
+	all := make([]Field, 0, len(c.fields)+len(fields))
+	all = append(all, c.fields...)
+	return &Context{fields: append(all, fields...)}
+}
+
+func (c *Context) Tracef(format string, v ...interface{}) {
+	c.logf(LevelTrace, format, v...)
+}
+
+func (c *Context) Debugf(format string, v ...interface{}) {
+	c.logf(LevelDebug, format, v...)
+}
+
+func (c *Context) Infof(format string, v ...interface{}) {
+	c.logf(LevelInfo, format, v...)
+}
+
+func (c *Context) Warnf(format string, v ...interface{}) {
+	c.logf(LevelWarn, format, v...)
+}
+
+func (c *Context) Errorf(format string, v ...interface{}) {
+	c.logf(LevelError, format, v...)
+}
+
+// Printf writes to configured output regardless of the configured log priority.
+// Note that if the log priority is set to LoggingOff this output WILL BE suppressed.
+func (c *Context) Printf(format string, v ...interface{}) {
+	if EnabledFor(LevelError) {
+		mu.RLock()
+		l := logger
+		mu.RUnlock()
+
+		l.Log(Entry{Time: time.Now(), Level: LoggingOff, Message: fmt.Sprintf(format, v...), Fields: c.fields})
 	}
 }
 
-func Debugf(format string, v ...interface{}) {
-	if EnabledFor(LevelDebug) {
-		f := "[DEBUG] " + format
-		logger.Printf(f, v...)
+func (c *Context) logf(priority int, format string, v ...interface{}) {
+	if EnabledFor(priority) {
+		mu.RLock()
+		l := logger
+		mu.RUnlock()
+
+		l.Log(Entry{Time: time.Now(), Level: priority, Message: fmt.Sprintf(format, v...), Fields: c.fields})
 	}
 }
 
+var root = &Context{}
+
+func Tracef(format string, v ...interface{}) {
+	root.Tracef(format, v...)
+}
+
+func Debugf(format string, v ...interface{}) {
+	root.Debugf(format, v...)
+}
+
 func Infof(format string, v ...interface{}) {
-	if EnabledFor(LevelInfo) {
-		f := "[INFO] " + format
-		logger.Printf(f, v...)
-	}
+	root.Infof(format, v...)
 }
 
 func Warnf(format string, v ...interface{}) {
-	if EnabledFor(LevelWarn) {
-		f := "[WARN] " + format
-		logger.Printf(f, v...)
-	}
+	root.Warnf(format, v...)
 }
 
 func Errorf(format string, v ...interface{}) {
-	if EnabledFor(LevelError) {
-		f := "[ERROR] " + format
-		logger.Printf(f, v...)
-	}
+	root.Errorf(format, v...)
 }
 
 // Printf writes to configured output regardless of the configured log priority.
 // Note that if the log priority is set to LoggingOff this output WILL BE suppressed.
 func Printf(format string, v ...interface{}) {
-	if rootPriority != LoggingOff {
-		logger.Printf(format, v...)
-	}
+	root.Printf(format, v...)
 }
 
 func init() {
 	rootPriority = LevelError
 
 	// Logs are always written to STDERR.
-	logger = log.New(os.Stderr, "", 0)
+	logger = NewTextLogger(os.Stderr)
 }
@@ -0,0 +1,230 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lookup finds the route map segment that applies to an IP address.
+package lookup
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"net"
+	"sort"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+)
+
+// Match is the result of a successful lookup.
+type Match struct {
+	// Network is the most specific network containing the address.
+	Network string `json:"network"`
+
+	// Labels are the labels of the segment the network belongs to.
+	Labels []string `json:"labels"`
+
+	// Segment is the index of that segment in the route map.
+	Segment int `json:"segment"`
+}
+
+// prefix is a network in a prefixSet.
+type prefix struct {
+	key  [net.IPv6len]byte
+	ones int
+}
+
+// prefixSet holds the networks of one address family, keyed by prefix length
+// and then by masked address.
+type prefixSet struct {
+	lengths []int // Descending, so the first hit is the longest match.
+	nets    map[int]map[[net.IPv6len]byte]int
+	sorted  []prefix // By address then prefix length, once the set is built.
+}
+
+func (p *prefixSet) add(ipnet *net.IPNet, entry int) bool {
+	ones, _ := ipnet.Mask.Size()
+
+	byKey, ok := p.nets[ones]
+	if !ok {
+		byKey = map[[net.IPv6len]byte]int{}
+		p.nets[ones] = byKey
+
+		p.lengths = append(p.lengths, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(p.lengths)))
+	}
+
+	k := key(ipnet.IP)
+	if _, dup := byKey[k]; dup {
+		return false
+	}
+
+	byKey[k] = entry
+	p.sorted = append(p.sorted, prefix{key: k, ones: ones})
+	return true
+}
+
+func (p *prefixSet) sort() {
+	sort.Slice(p.sorted, func(i, j int) bool {
+		if c := bytes.Compare(p.sorted[i].key[:], p.sorted[j].key[:]); c != 0 {
+			return c < 0
+		}
+		return p.sorted[i].ones < p.sorted[j].ones
+	})
+}
+
+// scope returns the shortest prefix length, at least matched, of a block
+// around ip that holds no network more specific than the one ip matched.
+// Only networks that do not contain ip need to be kept out, and the ones
+// sharing the most leading bits with ip are its neighbours in address order.
+func (p *prefixSet) scope(ip net.IP, bits int, matched int) int {
+	k := key(ip)
+	offset := 8*net.IPv6len - bits // Leading bits of IPv4 keys are all the same.
+
+	scope := matched
+
+	next := sort.Search(len(p.sorted), func(i int) bool {
+		return bytes.Compare(p.sorted[i].key[:], k[:]) > 0
+	})
+
+	if next < len(p.sorted) {
+		if common := commonBits(p.sorted[next].key, k) - offset; common+1 > scope {
+			scope = common + 1
+		}
+	}
+
+	for i := next - 1; i >= 0; i-- {
+		common := commonBits(p.sorted[i].key, k) - offset
+		if common >= p.sorted[i].ones {
+			continue // Contains ip.
+		}
+
+		if common+1 > scope {
+			scope = common + 1
+		}
+		break
+	}
+
+	return scope
+}
+
+// commonBits returns the number of leading bits a and b have in common.
+func commonBits(a, b [net.IPv6len]byte) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return 8*i + bits.LeadingZeros8(x)
+		}
+	}
+
+	return 8 * net.IPv6len
+}
+
+func (p *prefixSet) lookup(ip net.IP, bits int) (int, bool) {
+	for _, ones := range p.lengths {
+		masked := ip.Mask(net.CIDRMask(ones, bits))
+		if entry, ok := p.nets[ones][key(masked)]; ok {
+			return entry, true
+		}
+	}
+
+	return 0, false
+}
+
+func key(ip net.IP) [net.IPv6len]byte {
+	var k [net.IPv6len]byte
+	copy(k[:], ip.To16())
+	return k
+}
+
+// Table answers longest-prefix-match queries against a route map. It is safe
+// for concurrent lookups.
+type Table struct {
+	matches []Match
+	v4, v6  prefixSet
+}
+
+// New builds a Table from a route map. When the same network appears in more
+// than one segment, the first segment wins.
+func New(root *model.RoutemapRoot) (*Table, error) {
+	t := &Table{
+		v4: prefixSet{nets: map[int]map[[net.IPv6len]byte]int{}},
+		v6: prefixSet{nets: map[int]map[[net.IPv6len]byte]int{}},
+	}
+
+	for idx, m := range root.Routemap {
+		for _, n := range m.Networks {
+			_, ipnet, err := net.ParseCIDR(n)
+			if err != nil {
+				return nil, fmt.Errorf("unparsable network address %q (map segment index=%d)", n, idx)
+			}
+
+			set := &t.v6
+			if _, bits := ipnet.Mask.Size(); bits == 8*net.IPv4len {
+				set = &t.v4
+			}
+
+			if set.add(ipnet, len(t.matches)) {
+				t.matches = append(t.matches, Match{Network: ipnet.String(), Labels: m.Labels, Segment: idx})
+			}
+		}
+	}
+
+	t.v4.sort()
+	t.v6.sort()
+
+	return t, nil
+}
+
+// Len returns the number of distinct networks in the table.
+func (t *Table) Len() int {
+	return len(t.matches)
+}
+
+// Lookup returns the most specific network containing ip.
+func (t *Table) Lookup(ip net.IP) (Match, bool) {
+	var (
+		entry int
+		ok    bool
+	)
+
+	if v4 := ip.To4(); v4 != nil {
+		entry, ok = t.v4.lookup(v4, 8*net.IPv4len)
+	} else if len(ip) == net.IPv6len {
+		entry, ok = t.v6.lookup(ip, 8*net.IPv6len)
+	}
+
+	if !ok {
+		return Match{}, false
+	}
+
+	return t.matches[entry], true
+}
+
+// Scope returns the shortest prefix length for which every address sharing
+// that many leading bits with ip gets the same result from Lookup. It is at
+// least the length of the network ip matches, and greater when more specific
+// networks lie inside that network near ip.
+func (t *Table) Scope(ip net.IP) int {
+	matched := 0
+	if m, ok := t.Lookup(ip); ok {
+		_, ipnet, _ := net.ParseCIDR(m.Network)
+		matched, _ = ipnet.Mask.Size()
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		return t.v4.scope(v4, 8*net.IPv4len, matched)
+	} else if len(ip) == net.IPv6len {
+		return t.v6.scope(ip, 8*net.IPv6len, matched)
+	}
+
+	return matched
+}
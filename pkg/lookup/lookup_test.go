@@ -0,0 +1,105 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lookup
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Table(t *testing.T) {
+	root := &model.RoutemapRoot{
+		Routemap: []model.Routemap{
+			{Networks: []string{"10.0.0.0/8", "2001:db8::/32"}, Labels: []string{"wide"}},
+			{Networks: []string{"10.1.0.0/16", "2001:db8:1::/48"}, Labels: []string{"narrow", "wide"}},
+			{Networks: []string{"10.0.0.0/8"}, Labels: []string{"shadowed"}},
+		},
+	}
+
+	table, err := New(root)
+	require.NoError(t, err)
+	assert.Equal(t, 4, table.Len())
+
+	tests := []struct {
+		ip      string
+		network string
+		segment int
+		found   bool
+	}{
+		{"10.2.3.4", "10.0.0.0/8", 0, true},
+		{"10.1.3.4", "10.1.0.0/16", 1, true},
+		{"::ffff:10.1.3.4", "10.1.0.0/16", 1, true},
+		{"2001:db8:1::1", "2001:db8:1::/48", 1, true},
+		{"2001:db8:2::1", "2001:db8::/32", 0, true},
+		{"192.0.2.1", "", 0, false},
+		{"2001:db9::1", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		m, ok := table.Lookup(net.ParseIP(tt.ip))
+		assert.Equal(t, tt.found, ok, tt.ip)
+		assert.Equal(t, tt.network, m.Network, tt.ip)
+		assert.Equal(t, tt.segment, m.Segment, tt.ip)
+	}
+
+	m, _ := table.Lookup(net.ParseIP("10.1.0.1"))
+	assert.Equal(t, []string{"narrow", "wide"}, m.Labels)
+}
+
+func Test_Table_Scope(t *testing.T) {
+	root := &model.RoutemapRoot{
+		Routemap: []model.Routemap{
+			{Networks: []string{"10.0.0.0/16", "2001:db8::/32"}, Labels: []string{"wide"}},
+			{Networks: []string{"10.0.5.0/24", "10.0.7.0/24", "2001:db8:1::/48"}, Labels: []string{"narrow"}},
+		},
+	}
+
+	table, err := New(root)
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip    string
+		scope int
+	}{
+		{"10.0.5.1", 24},         // In a /24.
+		{"10.0.4.1", 24},         // In the /16, next to 10.0.5.0/24.
+		{"10.0.6.1", 24},         // Between the /24s.
+		{"10.0.200.1", 17},       // In the /16, far from the /24s.
+		{"10.0.0.1", 22},         // 10.0.0.0/22 is clear of the /24s.
+		{"10.1.0.1", 16},         // Unmatched, next to 10.0.0.0/16.
+		{"192.0.2.1", 1},         // Unmatched, nothing in 128.0.0.0/1.
+		{"2001:db8:1::1", 48},    // In the /48.
+		{"2001:db8:2::1", 47},    // In the /32, next to the /48.
+		{"2001:db8:8000::1", 33}, // In the /32, far from the /48.
+		{"::ffff:10.0.200.1", 17},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.scope, table.Scope(net.ParseIP(tt.ip)), tt.ip)
+	}
+}
+
+func Test_Table_invalidNetwork(t *testing.T) {
+	root := &model.RoutemapRoot{
+		Routemap: []model.Routemap{{Networks: []string{"10.0.0.0/33"}, Labels: []string{"a"}}},
+	}
+
+	_, err := New(root)
+	assert.EqualError(t, err, `unparsable network address "10.0.0.0/33" (map segment index=0)`)
+}
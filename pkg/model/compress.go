@@ -0,0 +1,82 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ns1/pulsar-routemap/pkg/lg"
+)
+
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CompressedExtensions are the filename extensions of the compressed formats
+// that LoadRoutemap understands.
+var CompressedExtensions = []string{".gz", ".bz2", ".zst"}
+
+// TrimCompressedExtension removes a compressed format's extension, if any,
+// from filename. For example, "map.json.gz" becomes "map.json".
+func TrimCompressedExtension(filename string) string {
+	for _, ext := range CompressedExtensions {
+		if strings.HasSuffix(filename, ext) {
+			return strings.TrimSuffix(filename, ext)
+		}
+	}
+
+	return filename
+}
+
+// decompress sniffs the first bytes of r and, if they identify a supported
+// compression format, returns a reader of the decompressed stream. Otherwise
+// r is returned as is.
+func decompress(r *bufio.Reader) (io.ReadCloser, error) {
+	// A short or failed peek just means the input is too small to be
+	// compressed; the JSON decoder will report any read error.
+	head, _ := r.Peek(len(magicZstd))
+
+	switch {
+	case bytes.HasPrefix(head, magicGzip):
+		lg.Debugf("decompressing gzip input")
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading gzip input: %v", err)
+		}
+		return zr, nil
+	case bytes.HasPrefix(head, magicBzip2):
+		lg.Debugf("decompressing bzip2 input")
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case bytes.HasPrefix(head, magicZstd):
+		lg.Debugf("decompressing zstd input")
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading zstd input: %v", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return ioutil.NopCloser(r), nil
+	}
+}
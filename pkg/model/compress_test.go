@@ -0,0 +1,72 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadCompressed(t *testing.T) {
+	plain, err := ioutil.ReadFile("testdata/simple.json")
+	require.NoError(t, err)
+	plainSHA1 := sha1.Sum(plain)
+
+	for _, name := range []string{"simple.json", "simple.json.gz", "simple.json.bz2", "simple.json.zst"} {
+		root, err := LoadRoutemapFilename("testdata/" + name)
+		require.NoError(t, err, name)
+
+		assert.Equal(t, plainSHA1[:], root.SHA1, name)
+		assert.Equal(t, len(plain), root.SizeInBytes, name)
+		assert.Equal(t, plain, root.Raw, name)
+		assert.Equal(t, 1, root.MetaVersion(), name)
+		assert.Len(t, root.Routemap, 1, name)
+	}
+}
+
+func Test_loadCorruptCompressed(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/simple.json.gz")
+	require.NoError(t, err)
+
+	_, err = LoadRoutemap(bytes.NewReader(data[:len(data)/2]))
+	assert.Error(t, err)
+}
+
+func Test_trimCompressedExtension(t *testing.T) {
+	assert.Equal(t, "map.json", TrimCompressedExtension("map.json.gz"))
+	assert.Equal(t, "map.json", TrimCompressedExtension("map.json.zst"))
+	assert.Equal(t, "map.json", TrimCompressedExtension("map.json"))
+}
+
+func Test_loadRoutemapLimit(t *testing.T) {
+	plain, err := ioutil.ReadFile("testdata/simple.json")
+	require.NoError(t, err)
+
+	for _, name := range []string{"simple.json", "simple.json.gz", "simple.json.zst"} {
+		data, err := ioutil.ReadFile("testdata/" + name)
+		require.NoError(t, err)
+
+		_, err = LoadRoutemapLimit(bytes.NewReader(data), int64(len(plain)))
+		assert.NoError(t, err, name)
+
+		_, err = LoadRoutemapLimit(bytes.NewReader(data), int64(len(plain)-1))
+		assert.Equal(t, ErrRoutemapTooLarge, err, name)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func isCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, magicGzip) || bytes.HasPrefix(data, magicBzip2) || bytes.HasPrefix(data, magicZstd)
+}
+
+// FuzzLoadRoutemap checks that the loader never panics on malformed input and
+// that SHA1, SizeInBytes and Raw always describe the whole uncompressed input.
+// The seed corpus is in testdata/fuzz/FuzzLoadRoutemap.
+func FuzzLoadRoutemap(f *testing.F) {
+	for _, name := range []string{"simple.json", "simple.json.gz", "simple.json.bz2", "simple.json.zst"} {
+		data, err := ioutil.ReadFile("testdata/" + name)
+		require.NoError(f, err)
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		root, err := LoadRoutemap(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		sum := sha1.Sum(root.Raw)
+		assert.Equal(t, sum[:], root.SHA1)
+		assert.Equal(t, len(root.Raw), root.SizeInBytes)
+
+		if !isCompressed(data) {
+			assert.Equal(t, data, root.Raw)
+		}
+
+		// What was loaded survives a round trip through JSON.
+		encoded, err := json.Marshal(root)
+		require.NoError(t, err)
+
+		again, err := LoadRoutemap(bytes.NewReader(encoded))
+		require.NoError(t, err)
+		assert.Equal(t, len(root.Routemap), len(again.Routemap))
+	})
+}
+
+func Test_LoadRoutemap_trailingData(t *testing.T) {
+	simple, err := ioutil.ReadFile("testdata/simple.json")
+	require.NoError(t, err)
+
+	// Whatever the length of the map, trailing whitespace is part of the
+	// hashed content.
+	for pad := 0; pad < 1024; pad++ {
+		data := append(bytes.Repeat([]byte(" "), pad), simple...)
+		data = append(data, "\n\n"...)
+
+		root, err := LoadRoutemap(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		sum := sha1.Sum(data)
+		require.Equal(t, sum[:], root.SHA1, "padding %d", pad)
+		require.Equal(t, data, root.Raw, "padding %d", pad)
+	}
+
+	_, err = LoadRoutemap(bytes.NewReader(append(simple, "{}"...)))
+	assert.EqualError(t, err, "parsing route map: unexpected data after the route map")
+
+	_, err = LoadRoutemap(bytes.NewReader(append(simple, "]"...)))
+	assert.Error(t, err)
+}
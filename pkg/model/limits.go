@@ -0,0 +1,28 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// Default customer-specific limits. These may be increased per customer.
+const (
+	DefaultMaxSegments  = 100000
+	DefaultMaxSizeBytes = 450 * 1024 * 1024
+)
+
+// Limits are the customer-specific limits on a route map. A zero value means
+// the limit is not checked.
+type Limits struct {
+	MaxSegments  int `yaml:"max_segments"`
+	MaxSizeBytes int `yaml:"max_size_bytes"`
+}
@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -66,13 +67,40 @@ func LoadRoutemapFilename(filename string) (*RoutemapRoot, error) {
 
 // LoadRoutemapFile loads a route map from an already-opened file.
 func LoadRoutemapFile(source *os.File) (*RoutemapRoot, error) {
+	return LoadRoutemap(source)
+}
+
+// ErrRoutemapTooLarge is returned by LoadRoutemapLimit when the uncompressed
+// route map is larger than the limit.
+var ErrRoutemapTooLarge = errors.New("route map is too large")
+
+// LoadRoutemap loads a route map from a reader. Input compressed with gzip,
+// bzip2 or zstd is detected and decompressed transparently; SHA1, SizeInBytes
+// and Raw always describe the uncompressed JSON.
+func LoadRoutemap(source io.Reader) (*RoutemapRoot, error) {
+	return LoadRoutemapLimit(source, 0)
+}
+
+// LoadRoutemapLimit is LoadRoutemap for untrusted input. It fails with
+// ErrRoutemapTooLarge once more than maxBytes have been decompressed, so a
+// small compressed input cannot expand without bound. Zero means no limit.
+func LoadRoutemapLimit(source io.Reader, maxBytes int64) (*RoutemapRoot, error) {
 	rmap := &RoutemapRoot{}
 
-	r := bufio.NewReader(source)
+	r, err := decompress(bufio.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var limited io.Reader = r
+	if maxBytes > 0 {
+		limited = &limitReader{r: r, remaining: maxBytes}
+	}
 
 	// Stream updates to hash function
 	fileHash := sha1.New()
-	teeHash := io.TeeReader(r, fileHash)
+	teeHash := io.TeeReader(limited, fileHash)
 
 	// Save raw bytes.
 	bytesBuf := bytes.Buffer{}
@@ -89,6 +117,18 @@ func LoadRoutemapFile(source *os.File) (*RoutemapRoot, error) {
 		}
 	}
 
+	// The decoder stops reading at the end of the route map, which may leave
+	// trailing whitespace unread. Read it so that SHA1 and Raw cover the whole
+	// input, and reject anything else after the route map.
+	if _, err := dec.Token(); err != io.EOF {
+		if err == ErrRoutemapTooLarge {
+			return nil, err
+		} else if err == nil {
+			err = fmt.Errorf("unexpected data after the route map")
+		}
+		return nil, fmt.Errorf("parsing route map: %v", err)
+	}
+
 	rmap.SHA1 = fileHash.Sum(nil)
 	rmap.Raw = bytesBuf.Bytes()
 	rmap.SizeInBytes = len(rmap.Raw)
@@ -96,6 +136,32 @@ func LoadRoutemapFile(source *os.File) (*RoutemapRoot, error) {
 	return rmap, nil
 }
 
+// limitReader reads from r until remaining bytes have been read and then
+// fails with ErrRoutemapTooLarge, unlike io.LimitReader which reports EOF.
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Only fail if there is more to read.
+		var b [1]byte
+		if n, err := l.r.Read(b[:]); n == 0 {
+			return 0, err
+		}
+		return 0, ErrRoutemapTooLarge
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
 func (r *RoutemapRoot) MetaVersion() int {
 	if v, ok := r.Meta["version"]; ok {
 		switch t := v.(type) {
@@ -0,0 +1,41 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"crypto/sha1"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadTrailingData(t *testing.T) {
+	const doc = `{"meta":{"version":1},"map":[]}`
+
+	// Trailing whitespace is part of the file and so of its SHA1.
+	input := doc + "\n\n"
+	root, err := LoadRoutemap(strings.NewReader(input))
+	require.NoError(t, err)
+	sum := sha1.Sum([]byte(input))
+	assert.Equal(t, sum[:], root.SHA1)
+	assert.Equal(t, len(input), root.SizeInBytes)
+
+	for _, trailing := range []string{"x", "{}", doc} {
+		_, err = LoadRoutemap(strings.NewReader(doc + "\n" + trailing))
+		assert.Error(t, err, trailing)
+	}
+}
@@ -15,21 +15,248 @@
 package model
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math/big"
+	"math/bits"
+	"net"
+	"net/netip"
+	"sort"
 	"strings"
 )
 
+// Coverage is the address space covered by a set of networks. Networks that
+// overlap are counted once in IPv4Addrs and IPv6Nets64, while the sums count
+// each network in full. IPv6 networks longer than /64 are not counted.
+type Coverage struct {
+	IPv4Addrs  *big.Int `json:"ipv4_addresses"`
+	IPv6Nets64 *big.Int `json:"ipv6_nets64"` // /64 networks covered
+
+	IPv4AddrsSum  *big.Int `json:"ipv4_addresses_sum"`
+	IPv6Nets64Sum *big.Int `json:"ipv6_nets64_sum"`
+
+	// Networks added since the last Finish.
+	networks []network
+
+	// Running totals of countNext, in the order of the fields above, and the
+	// last network it counted towards IPv4Addrs or IPv6Nets64.
+	counts  [4]count128
+	last    network
+	hasLast bool
+}
+
+func NewCoverage() Coverage {
+	return Coverage{
+		IPv4Addrs:     new(big.Int),
+		IPv6Nets64:    new(big.Int),
+		IPv4AddrsSum:  new(big.Int),
+		IPv6Nets64Sum: new(big.Int),
+	}
+}
+
+// Add adds the address space of ipnet. It is counted by Finish.
+func (c *Coverage) Add(ipnet *net.IPNet) {
+	ones, bits := ipnet.Mask.Size()
+
+	ip := ipnet.IP.To16()
+	if bits == 32 {
+		ip = ipnet.IP.To4()
+	}
+
+	addr, _ := netip.AddrFromSlice(ip)
+	c.AddPrefix(netip.PrefixFrom(addr, ones))
+}
+
+// AddPrefix is Add for a netip.Prefix.
+func (c *Coverage) AddPrefix(p netip.Prefix) {
+	p = p.Masked()
+
+	switch {
+	case p.Addr().Is4():
+		a := p.Addr().As4()
+		c.networks = append(c.networks, network{
+			addr: uint64(binary.BigEndian.Uint32(a[:])),
+			ones: uint8(p.Bits()),
+		})
+	case p.Bits() <= 64:
+		a := p.Addr().As16()
+		c.networks = append(c.networks, network{
+			addr: binary.BigEndian.Uint64(a[:8]),
+			ones: uint8(p.Bits()),
+			v6:   true,
+		})
+	}
+}
+
+// Finish counts the address space of the networks added so far. Call it once
+// all of them have been added.
+func (c *Coverage) Finish() {
+	if len(c.networks) == 0 {
+		return
+	}
+
+	networks := c.networks
+	sort.Sort(byAddress(networks))
+
+	*c = NewCoverage()
+	for _, n := range networks {
+		c.countNext(n)
+	}
+	c.settle()
+}
+
+// countNext counts n, given networks in the order sorted by byAddress. CIDR
+// networks are either nested or disjoint, so in that order a network that
+// overlaps one already counted lies within the last one counted.
+func (c *Coverage) countNext(n network) {
+	family := 0
+	if n.v6 {
+		family = 1
+	}
+
+	c.counts[2+family].addPow2(n.hostBits() - int(n.ones))
+
+	if c.hasLast && c.last.contains(n) {
+		return
+	}
+
+	c.last, c.hasLast = n, true
+	c.counts[family].addPow2(n.hostBits() - int(n.ones))
+}
+
+// settle sets the exported fields from the totals of countNext.
+func (c *Coverage) settle() {
+	c.counts[0].setBig(c.IPv4Addrs)
+	c.counts[1].setBig(c.IPv6Nets64)
+	c.counts[2].setBig(c.IPv4AddrsSum)
+	c.counts[3].setBig(c.IPv6Nets64Sum)
+}
+
+// network is a network counted by Coverage: an IPv4 network, or an IPv6
+// network of /64 or shorter identified by its first 64 bits.
+type network struct {
+	addr    uint64 // IPv4 addresses are in the low 32 bits.
+	ones    uint8
+	v6      bool
+	segment int32 // Used by RoutemapSummary.
+}
+
+// hostBits returns the number of bits that count towards the size of n.
+func (n network) hostBits() int {
+	if n.v6 {
+		return 64
+	}
+
+	return 32
+}
+
+// contains reports whether o lies within n.
+func (n network) contains(o network) bool {
+	return n.v6 == o.v6 && n.ones <= o.ones && (n.addr^o.addr)>>uint(n.hostBits()-int(n.ones)) == 0
+}
+
+// byAddress sorts networks by family and address, and then from least to
+// most specific.
+type byAddress []network
+
+func (b byAddress) Len() int {
+	return len(b)
+}
+
+func (b byAddress) Less(i, j int) bool {
+	switch {
+	case b[i].v6 != b[j].v6:
+		return !b[i].v6
+	case b[i].addr != b[j].addr:
+		return b[i].addr < b[j].addr
+	default:
+		return b[i].ones < b[j].ones
+	}
+}
+
+func (b byAddress) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+// count128 is an unsigned 128-bit count. A /0 IPv6 network is 2^64 /64
+// networks, so sums of network sizes need more than 64 bits.
+type count128 struct {
+	hi, lo uint64
+}
+
+// addPow2 adds 2^exp, where exp is at most 64.
+func (c *count128) addPow2(exp int) {
+	if exp == 64 {
+		c.hi++
+		return
+	}
+
+	var carry uint64
+	c.lo, carry = bits.Add64(c.lo, 1<<uint(exp), 0)
+	c.hi += carry
+}
+
+func (c count128) setBig(b *big.Int) {
+	b.SetUint64(c.hi)
+	b.Lsh(b, 64)
+	b.Or(b, new(big.Int).SetUint64(c.lo))
+}
+
+// LabelSummary describes the map segments with a label.
+type LabelSummary struct {
+	Segments int `json:"segments"`
+	Networks int `json:"networks"`
+	Coverage
+}
+
 type RoutemapSummary struct {
-	NumNetworks int
-	NumIPv4     int
-	NumIPv6     int
+	NumNetworks int `json:"networks"`
+	NumIPv4     int `json:"ipv4_networks"`
+	NumIPv6     int `json:"ipv6_networks"`
+
+	// Number of networks of each prefix length.
+	IPv4PrefixLengths map[int]int `json:"ipv4_prefix_lengths"`
+	IPv6PrefixLengths map[int]int `json:"ipv6_prefix_lengths"`
+
+	Coverage Coverage `json:"coverage"`
+
+	// LabelDistribution is the number of segments with each label. The same
+	// counts are in Labels.
+	LabelDistribution map[string]int `json:"-"`
+
+	Labels map[string]*LabelSummary `json:"labels"`
+
+	// The map segments credited to labels so far, in order.
+	segments         []labelledSegment
+	labelledNetworks int
+}
 
-	LabelDistribution map[string]int
+// labelledSegment is a map segment whose networks end at index end of the
+// summary's Coverage.
+type labelledSegment struct {
+	end    int
+	labels []string
 }
 
 func NewRoutemapSummary() RoutemapSummary {
-	return RoutemapSummary{LabelDistribution: map[string]int{}}
+	return RoutemapSummary{
+		IPv4PrefixLengths: map[int]int{},
+		IPv6PrefixLengths: map[int]int{},
+		Coverage:          NewCoverage(),
+		LabelDistribution: map[string]int{},
+		Labels:            map[string]*LabelSummary{},
+	}
+}
+
+func (s *RoutemapSummary) label(label string) *LabelSummary {
+	ls, ok := s.Labels[label]
+	if !ok {
+		ls = &LabelSummary{Coverage: NewCoverage()}
+		s.Labels[label] = ls
+	}
+
+	return ls
 }
 
 // SummarizeLabel adds label to LabelDistribution and returns
@@ -38,9 +265,121 @@ func (s *RoutemapSummary) SummarizeLabel(label string) int {
 	v := s.LabelDistribution[label]
 	v += 1
 	s.LabelDistribution[label] = v
+	s.label(label).Segments = v
 	return v
 }
 
+// SummarizeNetwork adds a valid network to the counts, prefix length
+// histograms and coverage. It does not change NumNetworks, which also counts
+// invalid networks.
+func (s *RoutemapSummary) SummarizeNetwork(ipnet *net.IPNet) {
+	ones, bits := ipnet.Mask.Size()
+	s.summarize(ones, bits)
+	s.Coverage.Add(ipnet)
+}
+
+// SummarizePrefix is SummarizeNetwork for a netip.Prefix.
+func (s *RoutemapSummary) SummarizePrefix(p netip.Prefix) {
+	s.summarize(p.Bits(), p.Addr().BitLen())
+	s.Coverage.AddPrefix(p)
+}
+
+func (s *RoutemapSummary) summarize(ones, bits int) {
+	if bits == 32 {
+		s.NumIPv4++
+		s.IPv4PrefixLengths[ones]++
+	} else {
+		s.NumIPv6++
+		s.IPv6PrefixLengths[ones]++
+	}
+}
+
+// SummarizeLabelNetworks credits the networks of a map segment, those
+// summarized since it was last called, to each of the segment's labels.
+func (s *RoutemapSummary) SummarizeLabelNetworks(labels []string) {
+	numNetworks := s.NumNetworks - s.labelledNetworks
+	s.labelledNetworks = s.NumNetworks
+
+	for _, lbl := range labels {
+		s.label(lbl).Networks += numNetworks
+	}
+
+	s.segments = append(s.segments, labelledSegment{end: len(s.Coverage.networks), labels: labels})
+}
+
+// Finish counts the address space covered by the map and by each label. Call
+// it once the whole map has been summarized.
+func (s *RoutemapSummary) Finish() {
+	networks := s.Coverage.networks
+	if len(networks) == 0 {
+		return
+	}
+
+	// Note the segment of each network so that, once they are sorted, each can
+	// be counted for the segment's labels too.
+	i := 0
+	for seg, ls := range s.segments {
+		for ; i < ls.end; i++ {
+			networks[i].segment = int32(seg)
+		}
+	}
+	for ; i < len(networks); i++ {
+		networks[i].segment = -1
+	}
+
+	sort.Sort(byAddress(networks))
+
+	// The labels of segment seg are labels[first[seg]:first[seg+1]].
+	var (
+		labels []*LabelSummary
+		first  = make([]int, len(s.segments)+1)
+	)
+	for seg, ls := range s.segments {
+		first[seg] = len(labels)
+		for _, lbl := range ls.labels {
+			labels = append(labels, s.Labels[lbl])
+		}
+	}
+	first[len(s.segments)] = len(labels)
+
+	s.Coverage = NewCoverage()
+	for _, n := range networks {
+		s.Coverage.countNext(n)
+
+		if n.segment >= 0 {
+			for _, ls := range labels[first[n.segment]:first[n.segment+1]] {
+				ls.countNext(n)
+			}
+		}
+	}
+
+	s.Coverage.settle()
+	for _, ls := range s.Labels {
+		ls.settle()
+	}
+
+	s.segments = nil
+}
+
+func formatPrefixLengths(hist map[int]int) string {
+	if len(hist) == 0 {
+		return "none"
+	}
+
+	var lengths []int
+	for l := range hist {
+		lengths = append(lengths, l)
+	}
+	sort.Ints(lengths)
+
+	var values []string
+	for _, l := range lengths {
+		values = append(values, fmt.Sprintf("/%d: %d", l, hist[l]))
+	}
+
+	return strings.Join(values, ", ")
+}
+
 // PrettyPrint prints a formatted summary using the given Writer.
 func (s *RoutemapSummary) PrettyPrint(w io.Writer) {
 	fmt.Fprintf(w, "total networks: %d\n", s.NumNetworks)
@@ -48,10 +387,31 @@ func (s *RoutemapSummary) PrettyPrint(w io.Writer) {
 	fmt.Fprintf(w, "v6 addresses: %d\n", s.NumIPv6)
 	fmt.Fprintf(w, "total unique labels: %d\n", len(s.LabelDistribution))
 
-	var labels []string
+	var histogram []string
 	for k, v := range s.LabelDistribution {
-		labels = append(labels, fmt.Sprintf("%s: %d", k, v))
+		histogram = append(histogram, fmt.Sprintf("%s: %d", k, v))
 	}
+	sort.Strings(histogram)
 
-	fmt.Fprintf(w, "label histogram: %s\n", strings.Join(labels, ", "))
+	fmt.Fprintf(w, "label histogram: %s\n", strings.Join(histogram, ", "))
+
+	fmt.Fprintf(w, "v4 prefix lengths: %s\n", formatPrefixLengths(s.IPv4PrefixLengths))
+	fmt.Fprintf(w, "v6 prefix lengths: %s\n", formatPrefixLengths(s.IPv6PrefixLengths))
+	fmt.Fprintf(w, "v4 coverage: %s addresses (sum of prefix sizes %s)\n",
+		s.Coverage.IPv4Addrs, s.Coverage.IPv4AddrsSum)
+	fmt.Fprintf(w, "v6 coverage: %s /64 networks (sum of prefix sizes %s)\n",
+		s.Coverage.IPv6Nets64, s.Coverage.IPv6Nets64Sum)
+
+	var labels []string
+	for k := range s.Labels {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+
+	fmt.Fprintf(w, "labels:\n")
+	for _, k := range labels {
+		ls := s.Labels[k]
+		fmt.Fprintf(w, "  %s: %d segments, %d networks, covering %s v4 addresses, %s v6 /64 networks\n",
+			k, ls.Segments, ls.Networks, ls.IPv4Addrs, ls.IPv6Nets64)
+	}
 }
@@ -0,0 +1,111 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RoutemapSummary(t *testing.T) {
+	s := NewRoutemapSummary()
+
+	for _, segment := range []struct {
+		networks []string
+		labels   []string
+	}{
+		{[]string{"10.0.0.0/24", "2001:db8::/64"}, []string{"syd", "mel"}},
+		{[]string{"10.1.0.0/26"}, []string{"hkg"}},
+		// Both overlap earlier networks, so add to the sums but not to the
+		// address space covered.
+		{[]string{"10.0.0.0/25", "10.1.0.0/24"}, []string{"syd"}},
+	} {
+		for _, n := range segment.networks {
+			_, ipnet, err := net.ParseCIDR(n)
+			require.NoError(t, err)
+
+			s.NumNetworks++
+			s.SummarizeNetwork(ipnet)
+		}
+
+		for _, lbl := range segment.labels {
+			s.SummarizeLabel(lbl)
+		}
+
+		s.SummarizeLabelNetworks(segment.labels)
+	}
+
+	s.Finish()
+
+	buf := &bytes.Buffer{}
+	s.PrettyPrint(buf)
+	assert.Equal(t, `total networks: 5
+v4 addresses: 4
+v6 addresses: 1
+total unique labels: 3
+label histogram: hkg: 1, mel: 1, syd: 2
+v4 prefix lengths: /24: 2, /25: 1, /26: 1
+v6 prefix lengths: /64: 1
+v4 coverage: 512 addresses (sum of prefix sizes 704)
+v6 coverage: 1 /64 networks (sum of prefix sizes 1)
+labels:
+  hkg: 1 segments, 1 networks, covering 64 v4 addresses, 0 v6 /64 networks
+  mel: 1 segments, 2 networks, covering 256 v4 addresses, 1 v6 /64 networks
+  syd: 2 segments, 4 networks, covering 512 v4 addresses, 1 v6 /64 networks
+`, buf.String())
+
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"networks": 5, "ipv4_networks": 4, "ipv6_networks": 1,
+		"ipv4_prefix_lengths": {"24": 2, "25": 1, "26": 1},
+		"ipv6_prefix_lengths": {"64": 1},
+		"coverage": {"ipv4_addresses": 512, "ipv6_nets64": 1, "ipv4_addresses_sum": 704, "ipv6_nets64_sum": 1},
+		"labels": {
+			"hkg": {"segments": 1, "networks": 1,
+				"ipv4_addresses": 64, "ipv6_nets64": 0, "ipv4_addresses_sum": 64, "ipv6_nets64_sum": 0},
+			"mel": {"segments": 1, "networks": 2,
+				"ipv4_addresses": 256, "ipv6_nets64": 1, "ipv4_addresses_sum": 256, "ipv6_nets64_sum": 1},
+			"syd": {"segments": 2, "networks": 4,
+				"ipv4_addresses": 512, "ipv6_nets64": 1, "ipv4_addresses_sum": 640, "ipv6_nets64_sum": 1}
+		}}`, string(data))
+}
+
+func Test_Coverage(t *testing.T) {
+	c := NewCoverage()
+	for _, n := range []string{
+		"10.0.0.0/8", "10.1.0.0/16", "10.0.0.0/8", "192.0.2.0/24", "192.0.2.128/25",
+		"2001:db8::/32", "2001:db8:1::/48", "2001:db8::/127", "::ffff:10.0.0.0/104",
+	} {
+		_, ipnet, err := net.ParseCIDR(n)
+		require.NoError(t, err)
+		c.Add(ipnet)
+	}
+
+	c.Finish()
+	assert.Equal(t, "16777472", c.IPv4Addrs.String())       // 10/8 and 192.0.2.0/24
+	assert.Equal(t, "33620352", c.IPv4AddrsSum.String())    // 2 x 10/8, 10.1/16, /24 and /25
+	assert.Equal(t, "4294967296", c.IPv6Nets64.String())    // 2001:db8::/32; the /104 is too long
+	assert.Equal(t, "4295032832", c.IPv6Nets64Sum.String()) // and the /48
+
+	// Finishing again changes nothing.
+	c.Finish()
+	assert.Equal(t, "16777472", c.IPv4Addrs.String())
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// NewRoutemapRoot creates an empty route map of the current version.
+func NewRoutemapRoot() *RoutemapRoot {
+	return &RoutemapRoot{Meta: map[string]interface{}{"version": 1}, Routemap: []Routemap{}}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteTo writes the route map as JSON with one map segment per line, which
+// keeps large maps readable and diffable. Raw, SHA1 and SizeInBytes are not
+// updated.
+func (r *RoutemapRoot) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	enc := json.NewEncoder(buf)
+
+	meta, err := json.Marshal(r.Meta)
+	if err != nil {
+		return 0, err
+	}
+
+	buf.WriteString(`{"meta":`)
+	buf.Write(meta)
+	buf.WriteString(`,"map":[` + "\n")
+
+	for idx, m := range r.Routemap {
+		if idx > 0 {
+			buf.WriteString(",")
+		}
+
+		// Encode ends each segment with a newline.
+		if err = enc.Encode(m); err != nil {
+			return counter.n, err
+		}
+	}
+
+	buf.WriteString("]}\n")
+
+	err = buf.Flush()
+	return counter.n, err
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy checks route map labels against local conventions that
+// Pulsar does not enforce: the fallback labels that segments must end with,
+// e.g. a regional then a global fallback, and the vocabulary of known labels.
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v2"
+)
+
+// File is the fallback policy file, in YAML or JSON.
+type File struct {
+	// Fallbacks maps a primary label to the labels that must end each segment
+	// it is the first label of, in order. An empty list exempts the label
+	// from Default.
+	Fallbacks map[string][]string `yaml:"fallbacks"`
+
+	// Default are the fallbacks for primary labels not in Fallbacks. If
+	// empty, those labels are not checked.
+	Default []string `yaml:"default"`
+}
+
+// Policy is a checked fallback policy. Labels are compared without regard to
+// case.
+type Policy struct {
+	chains       map[string][]string
+	defaultChain []string
+}
+
+// LoadFile reads the named policy file.
+func LoadFile(filename string) (*Policy, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f := File{}
+	if err = yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing fallback policy %s: %v", filename, err)
+	}
+
+	p, err := New(f)
+	if err != nil {
+		return nil, fmt.Errorf("fallback policy %s: %v", filename, err)
+	}
+
+	return p, nil
+}
+
+// New checks f and creates a Policy from it.
+func New(f File) (*Policy, error) {
+	var allErrs error
+
+	checkChain := func(name string, chain []string) {
+		for idx, l := range chain {
+			if len(strings.TrimSpace(l)) == 0 {
+				multierr.AppendInto(&allErrs, fmt.Errorf("empty fallback label for %s at index %d", name, idx))
+			} else if indexOf(chain[:idx], l) >= 0 {
+				multierr.AppendInto(&allErrs, fmt.Errorf("duplicate fallback label \"%s\" for %s", l, name))
+			}
+		}
+	}
+
+	p := &Policy{chains: make(map[string][]string), defaultChain: f.Default}
+	checkChain("default", f.Default)
+
+	// Sorted so that errors are reported in a stable order.
+	primaries := make([]string, 0, len(f.Fallbacks))
+	for primary := range f.Fallbacks {
+		primaries = append(primaries, primary)
+	}
+	sort.Strings(primaries)
+
+	for _, primary := range primaries {
+		chain := f.Fallbacks[primary]
+		key := strings.ToLower(primary)
+		if len(strings.TrimSpace(primary)) == 0 {
+			multierr.AppendInto(&allErrs, fmt.Errorf("empty primary label"))
+		} else if _, ok := p.chains[key]; ok {
+			multierr.AppendInto(&allErrs, fmt.Errorf("primary label \"%s\" is listed more than once", primary))
+		}
+
+		checkChain(fmt.Sprintf("\"%s\"", primary), chain)
+		p.chains[key] = chain
+	}
+
+	if allErrs != nil {
+		return nil, allErrs
+	}
+
+	return p, nil
+}
+
+// indexOf returns the index of label in labels, or -1.
+func indexOf(labels []string, label string) int {
+	for idx, l := range labels {
+		if strings.EqualFold(l, label) {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// Fallbacks returns the labels required at the end of a segment with the
+// given primary label. The primary label itself is left out if it is in the
+// chain, as it is already the first label.
+func (p *Policy) Fallbacks(primary string) []string {
+	chain, ok := p.chains[strings.ToLower(primary)]
+	if !ok {
+		chain = p.defaultChain
+	}
+
+	idx := indexOf(chain, primary)
+	if idx < 0 {
+		return chain
+	}
+
+	required := make([]string, 0, len(chain)-1)
+	required = append(required, chain[:idx]...)
+	return append(required, chain[idx+1:]...)
+}
+
+// Check returns an error if labels do not end with the fallbacks for the
+// first of them.
+func (p *Policy) Check(labels []string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	chain := p.Fallbacks(labels[0])
+	if hasSuffix(labels, chain) {
+		return nil
+	}
+
+	return fmt.Errorf("labels \"%s\" do not end with the fallbacks \"%s\" required for primary label \"%s\"",
+		strings.Join(labels, ","), strings.Join(chain, ","), labels[0])
+}
+
+func hasSuffix(labels, chain []string) bool {
+	if len(labels) < len(chain) {
+		return false
+	}
+
+	tail := labels[len(labels)-len(chain):]
+	for idx := range chain {
+		if !strings.EqualFold(tail[idx], chain[idx]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Complete returns labels with the fallbacks for the first of them at the
+// end, in order, and whether that changed them. Fallback labels found
+// elsewhere after the first label are moved to the end. The first label is
+// never moved.
+func (p *Policy) Complete(labels []string) ([]string, bool) {
+	if len(labels) == 0 || p.Check(labels) == nil {
+		return labels, false
+	}
+
+	chain := p.Fallbacks(labels[0])
+	out := make([]string, 0, len(labels)+len(chain))
+	out = append(out, labels[0])
+
+	for _, l := range labels[1:] {
+		if indexOf(chain, l) < 0 {
+			out = append(out, l)
+		}
+	}
+
+	return append(out, chain...), true
+}
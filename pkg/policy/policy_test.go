@@ -0,0 +1,141 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPolicy(t *testing.T) *Policy {
+	p, err := New(File{
+		Fallbacks: map[string][]string{
+			"syd":    {"apac", "global"},
+			"LHR":    {"emea", "global"},
+			"global": {},
+		},
+		Default: []string{"global"},
+	})
+	require.NoError(t, err)
+	return p
+}
+
+func Test_Check(t *testing.T) {
+	p := testPolicy(t)
+
+	for _, labels := range [][]string{
+		{"syd", "apac", "global"},
+		{"SYD", "mel", "APAC", "Global"},
+		{"lhr", "emea", "global"},
+		{"hkg", "global"},
+		{"global"},
+		{},
+	} {
+		assert.NoError(t, p.Check(labels), "%v", labels)
+	}
+
+	assert.EqualError(t, p.Check([]string{"syd", "global", "apac"}),
+		`labels "syd,global,apac" do not end with the fallbacks "apac,global" required for primary label "syd"`)
+	assert.EqualError(t, p.Check([]string{"hkg"}),
+		`labels "hkg" do not end with the fallbacks "global" required for primary label "hkg"`)
+}
+
+func Test_Complete(t *testing.T) {
+	p := testPolicy(t)
+
+	tests := []struct {
+		in, want []string
+		changed  bool
+	}{
+		{[]string{"syd", "apac", "global"}, []string{"syd", "apac", "global"}, false},
+		{[]string{"syd"}, []string{"syd", "apac", "global"}, true},
+		{[]string{"syd", "mel"}, []string{"syd", "mel", "apac", "global"}, true},
+		{[]string{"syd", "GLOBAL", "mel", "apac"}, []string{"syd", "mel", "apac", "global"}, true},
+		{[]string{"lhr", "global"}, []string{"lhr", "emea", "global"}, true},
+		{[]string{"hkg", "sin"}, []string{"hkg", "sin", "global"}, true},
+		{[]string{"global", "syd"}, []string{"global", "syd"}, false},
+	}
+
+	for _, tt := range tests {
+		got, changed := p.Complete(tt.in)
+		assert.Equal(t, tt.want, got, "%v", tt.in)
+		assert.Equal(t, tt.changed, changed, "%v", tt.in)
+		if changed {
+			assert.NoError(t, p.Check(got), "%v", tt.in)
+		}
+	}
+}
+
+func Test_primaryInChain(t *testing.T) {
+	p, err := New(File{
+		Fallbacks: map[string][]string{"syd": {"apac", "SYD", "global"}},
+		Default:   []string{"apac", "global"},
+	})
+	require.NoError(t, err)
+
+	// The primary label is not required again at the end.
+	assert.Equal(t, []string{"global"}, p.Fallbacks("APAC"))
+	assert.Equal(t, []string{"apac", "global"}, p.Fallbacks("syd"))
+	assert.NoError(t, p.Check([]string{"apac", "global"}))
+	assert.NoError(t, p.Check([]string{"syd", "apac", "global"}))
+
+	got, changed := p.Complete([]string{"apac", "syd"})
+	assert.True(t, changed)
+	assert.Equal(t, []string{"apac", "syd", "global"}, got)
+	assert.NoError(t, p.Check(got))
+
+	got, changed = p.Complete([]string{"syd", "global", "mel"})
+	assert.True(t, changed)
+	assert.Equal(t, []string{"syd", "mel", "apac", "global"}, got)
+	assert.NoError(t, p.Check(got))
+}
+
+func Test_New_invalid(t *testing.T) {
+	_, err := New(File{
+		Fallbacks: map[string][]string{
+			"syd": {"apac", "APAC"},
+			"SYD": {"global"},
+			"mel": {""},
+		},
+	})
+	assert.EqualError(t, err, `empty fallback label for "mel" at index 0; `+
+		`primary label "syd" is listed more than once; duplicate fallback label "APAC" for "syd"`)
+}
+
+func Test_LoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "fallbacks.yaml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`fallbacks:
+  syd: [apac, global]
+default: [global]
+`), 0644))
+
+	p, err := LoadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apac", "global"}, p.Fallbacks("Syd"))
+	assert.Equal(t, []string{"global"}, p.Fallbacks("hkg"))
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("fallback:\n  syd: [global]\n"), 0644))
+	_, err = LoadFile(filename)
+	assert.Error(t, err)
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Vocabulary is the set of labels a route map may use. Labels are matched
+// without regard to case, but are expected to be written as in the
+// vocabulary.
+type Vocabulary struct {
+	labels map[string]string // Keyed by lower case.
+	sorted []string
+}
+
+// LoadVocabularyFile reads the named file of labels, one per line. Blank lines
+// and lines starting with '#' are ignored.
+func LoadVocabularyFile(filename string) (*Vocabulary, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var labels []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		labels = append(labels, line)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading labels file %s: %v", filename, err)
+	}
+
+	v, err := NewVocabulary(labels)
+	if err != nil {
+		return nil, fmt.Errorf("labels file %s: %v", filename, err)
+	}
+
+	return v, nil
+}
+
+// NewVocabulary creates a Vocabulary of labels. Labels that differ only in
+// case are an error, as it would not be clear which is right.
+func NewVocabulary(labels []string) (*Vocabulary, error) {
+	v := &Vocabulary{labels: make(map[string]string, len(labels))}
+
+	for _, l := range labels {
+		key := strings.ToLower(l)
+		if prev, ok := v.labels[key]; ok {
+			if prev != l {
+				return nil, fmt.Errorf("labels \"%s\" and \"%s\" differ only in case", prev, l)
+			}
+			continue
+		}
+
+		v.labels[key] = l
+		v.sorted = append(v.sorted, l)
+	}
+
+	sort.Strings(v.sorted)
+
+	return v, nil
+}
+
+// Lookup returns the label as written in the vocabulary, if it is known.
+func (v *Vocabulary) Lookup(label string) (string, bool) {
+	l, ok := v.labels[strings.ToLower(label)]
+	return l, ok
+}
+
+// Suggest returns the known label closest to an unknown one, if any is close
+// enough to be a likely typo: at most one edit for every three characters.
+func (v *Vocabulary) Suggest(label string) (string, bool) {
+	var (
+		best     string
+		bestDist = len(label)/3 + 1
+	)
+
+	lower := strings.ToLower(label)
+	for _, l := range v.sorted {
+		if d := editDistance(lower, strings.ToLower(l)); d < bestDist {
+			best, bestDist = l, d
+		}
+	}
+
+	return best, len(best) > 0
+}
+
+// editDistance is the Levenshtein distance between a and b: the number of
+// characters that must be inserted, deleted or substituted to turn one into
+// the other.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
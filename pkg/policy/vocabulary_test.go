@@ -0,0 +1,89 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_editDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"syd", "syd", 0},
+		{"sydd", "syd", 1},
+		{"syd", "sid", 1},
+		{"sdy", "syd", 2},
+		{"", "mel", 3},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, editDistance(tt.a, tt.b), "%s %s", tt.a, tt.b)
+		assert.Equal(t, tt.want, editDistance(tt.b, tt.a), "%s %s", tt.b, tt.a)
+	}
+}
+
+func Test_Vocabulary(t *testing.T) {
+	v, err := NewVocabulary([]string{"syd", "mel", "frankfurt", "apac", "syd"})
+	require.NoError(t, err)
+
+	known, ok := v.Lookup("SYD")
+	assert.True(t, ok)
+	assert.Equal(t, "syd", known)
+
+	_, ok = v.Lookup("sydd")
+	assert.False(t, ok)
+
+	tests := []struct {
+		label, want string
+	}{
+		{"sydd", "syd"},
+		{"Sdy", ""}, // Two edits is too many for three characters.
+		{"frnkfurt", "frankfurt"},
+		{"frnkfrt", "frankfurt"},
+		{"lhr", ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := v.Suggest(tt.label)
+		assert.Equal(t, tt.want, got, tt.label)
+		assert.Equal(t, len(tt.want) > 0, ok, tt.label)
+	}
+
+	_, err = NewVocabulary([]string{"syd", "SYD"})
+	assert.EqualError(t, err, `labels "syd" and "SYD" differ only in case`)
+}
+
+func Test_LoadVocabularyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vocabulary")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "labels.txt")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("# POPs\nsyd\n  mel  \n\napac\n"), 0644))
+
+	v, err := LoadVocabularyFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apac", "mel", "syd"}, v.sorted)
+}
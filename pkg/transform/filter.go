@@ -0,0 +1,195 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+)
+
+// Address families for Filter.Family.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// Filter selects segments by their labels and networks by their address.
+// Unset fields match everything. Labels are compared without regard to case.
+type Filter struct {
+	// HasLabels are labels that a segment must all have.
+	HasLabels []string
+
+	// PrimaryLabels are labels of which a segment's first must be one.
+	PrimaryLabels []string
+
+	// LabelRegexp must match at least one of a segment's labels.
+	LabelRegexp *regexp.Regexp
+
+	// Within are networks of which a network must be inside one.
+	Within []netip.Prefix
+
+	// Family is FamilyIPv4 or FamilyIPv6.
+	Family string
+
+	// MinBits and MaxBits bound a network's prefix length. MaxBits is no
+	// bound when it is NoMaxBits, so a zero Filter only matches /0 networks.
+	MinBits, MaxBits int
+}
+
+// NoMaxBits is the Filter.MaxBits for no upper bound on the prefix length.
+const NoMaxBits = -1
+
+// ParseBitsRange parses a prefix length or range of them, such as "24",
+// "16-24", "-16" or "48-". The maximum of an open range is NoMaxBits.
+func ParseBitsRange(s string) (int, int, error) {
+	invalid := fmt.Errorf("invalid prefix length range '%s'", s)
+
+	lo, hi := s, s
+	if idx := strings.Index(s, "-"); idx >= 0 {
+		lo, hi = s[:idx], s[idx+1:]
+	}
+
+	var (
+		min int
+		max = NoMaxBits
+		err error
+	)
+
+	if len(lo) > 0 {
+		if min, err = strconv.Atoi(lo); err != nil || min < 0 || min > 128 {
+			return 0, 0, invalid
+		}
+	}
+	if len(hi) > 0 {
+		if max, err = strconv.Atoi(hi); err != nil || max < 0 || max > 128 {
+			return 0, 0, invalid
+		}
+	}
+
+	if len(lo) == 0 && len(hi) == 0 || max != NoMaxBits && min > max {
+		return 0, 0, invalid
+	}
+
+	return min, max, nil
+}
+
+// MatchSegment reports whether the labels of a segment match.
+func (f *Filter) MatchSegment(labels []string) bool {
+	for _, want := range f.HasLabels {
+		if !hasLabel(labels, want) {
+			return false
+		}
+	}
+
+	if len(f.PrimaryLabels) > 0 && (len(labels) == 0 || !hasLabel(f.PrimaryLabels, labels[0])) {
+		return false
+	}
+
+	if f.LabelRegexp != nil {
+		matched := false
+		for _, l := range labels {
+			if f.LabelRegexp.MatchString(l) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if strings.EqualFold(l, label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchNetwork reports whether a network matches.
+func (f *Filter) MatchNetwork(p netip.Prefix) bool {
+	switch f.Family {
+	case FamilyIPv4:
+		if !p.Addr().Is4() {
+			return false
+		}
+	case FamilyIPv6:
+		if p.Addr().Is4() {
+			return false
+		}
+	}
+
+	if p.Bits() < f.MinBits || f.MaxBits != NoMaxBits && p.Bits() > f.MaxBits {
+		return false
+	}
+
+	if len(f.Within) == 0 {
+		return true
+	}
+
+	for _, w := range f.Within {
+		if p.Bits() >= w.Bits() && w.Contains(p.Addr()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Apply returns the matching networks of the matching segments of root, in
+// their original order. Segments left without networks are dropped. The
+// second result is the index in root of each segment that was kept.
+func (f *Filter) Apply(root *model.RoutemapRoot) (*model.RoutemapRoot, []int, error) {
+	out := model.NewRoutemapRoot()
+	out.Meta = root.Meta
+
+	var indexes []int
+
+	for idx, m := range root.Routemap {
+		if !f.MatchSegment(m.Labels) {
+			continue
+		}
+
+		var networks []string
+		for _, n := range m.Networks {
+			p, err := netip.ParsePrefix(n)
+			if err != nil {
+				return nil, nil, fmt.Errorf("network '%s' (map segment index=%d): %v", n, idx, err)
+			}
+
+			if f.MatchNetwork(p) {
+				networks = append(networks, n)
+			}
+		}
+
+		if len(networks) > 0 {
+			out.Routemap = append(out.Routemap, model.Routemap{Networks: networks, Labels: m.Labels})
+			indexes = append(indexes, idx)
+		}
+	}
+
+	return out, indexes, nil
+}
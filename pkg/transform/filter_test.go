@@ -0,0 +1,132 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"net/netip"
+	"regexp"
+	"testing"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseBitsRange(t *testing.T) {
+	tests := []struct {
+		in       string
+		min, max int
+		err      bool
+	}{
+		{"24", 24, 24, false},
+		{"16-24", 16, 24, false},
+		{"-16", 0, 16, false},
+		{"48-", 48, NoMaxBits, false},
+		{"0", 0, 0, false},
+		{"0-0", 0, 0, false},
+		{"-", 0, 0, true},
+		{"24-16", 0, 0, true},
+		{"129", 0, 0, true},
+		{"a-b", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		min, max, err := ParseBitsRange(tt.in)
+		if tt.err {
+			assert.EqualError(t, err, "invalid prefix length range '"+tt.in+"'")
+			continue
+		}
+
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.min, min, tt.in)
+		assert.Equal(t, tt.max, max, tt.in)
+	}
+}
+
+func Test_Filter(t *testing.T) {
+	root := rootOf(
+		model.Routemap{Networks: []string{"10.0.0.0/24", "10.1.0.0/16", "2001:db8::/48"}, Labels: []string{"syd", "mel"}},
+		model.Routemap{Networks: []string{"10.2.0.0/24"}, Labels: []string{"mel", "syd"}},
+		model.Routemap{Networks: []string{"192.0.2.0/24", "2001:db8:1::/64"}, Labels: []string{"hkg"}})
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		want    []model.Routemap
+		indexes []int
+	}{
+		{
+			name:    "everything",
+			filter:  Filter{MaxBits: NoMaxBits},
+			want:    root.Routemap,
+			indexes: []int{0, 1, 2},
+		},
+		{
+			name:    "has label",
+			filter:  Filter{HasLabels: []string{"SYD", "mel"}, MaxBits: NoMaxBits},
+			want:    root.Routemap[:2],
+			indexes: []int{0, 1},
+		},
+		{
+			name:    "primary label",
+			filter:  Filter{PrimaryLabels: []string{"mel", "hkg"}, MaxBits: NoMaxBits},
+			want:    root.Routemap[1:],
+			indexes: []int{1, 2},
+		},
+		{
+			name:    "label regexp",
+			filter:  Filter{LabelRegexp: regexp.MustCompile("^h"), MaxBits: NoMaxBits},
+			want:    root.Routemap[2:],
+			indexes: []int{2},
+		},
+		{
+			name:   "within",
+			filter: Filter{Within: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/15"), netip.MustParsePrefix("2001:db8::/32")}, MaxBits: NoMaxBits},
+			want: []model.Routemap{
+				{Networks: []string{"10.0.0.0/24", "10.1.0.0/16", "2001:db8::/48"}, Labels: []string{"syd", "mel"}},
+				{Networks: []string{"2001:db8:1::/64"}, Labels: []string{"hkg"}},
+			},
+			indexes: []int{0, 2},
+		},
+		{
+			name:   "family and prefix length",
+			filter: Filter{Family: FamilyIPv4, MinBits: 24, MaxBits: 24},
+			want: []model.Routemap{
+				{Networks: []string{"10.0.0.0/24"}, Labels: []string{"syd", "mel"}},
+				{Networks: []string{"10.2.0.0/24"}, Labels: []string{"mel", "syd"}},
+				{Networks: []string{"192.0.2.0/24"}, Labels: []string{"hkg"}},
+			},
+			indexes: []int{0, 1, 2},
+		},
+		{
+			name:    "ipv6 from /56",
+			filter:  Filter{Family: FamilyIPv6, MinBits: 56, MaxBits: NoMaxBits},
+			want:    []model.Routemap{{Networks: []string{"2001:db8:1::/64"}, Labels: []string{"hkg"}}},
+			indexes: []int{2},
+		},
+		{
+			name:   "only /0",
+			filter: Filter{MaxBits: 0},
+			want:   []model.Routemap{},
+		},
+	}
+
+	for _, tt := range tests {
+		out, indexes, err := tt.filter.Apply(root)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.want, out.Routemap, tt.name)
+		assert.Equal(t, tt.indexes, indexes, tt.name)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transform rewrites route maps: merging several into one, splitting
+// one into several, and extracting or relabelling parts of one.
+package transform
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+)
+
+// Source is one input to Merge.
+type Source struct {
+	Name string // Used in the override report, e.g. the file name.
+	Root *model.RoutemapRoot
+}
+
+// Override records a network from one source that a later source took over
+// some or all of.
+type Override struct {
+	Network string `json:"network"`
+	Source  string `json:"source"`
+
+	By       string `json:"by"`
+	BySource string `json:"by_source"`
+
+	// Partial is set when By is more specific than Network, so that Network
+	// remains in the merged map for the rest of its addresses.
+	Partial bool `json:"partial"`
+}
+
+func (o Override) String() string {
+	verb := "overridden"
+	if o.Partial {
+		verb = "partly overridden"
+	}
+
+	return fmt.Sprintf("%s (%s) %s by %s (%s)", o.Network, o.Source, verb, o.By, o.BySource)
+}
+
+// entry is a network of one source and the labels of its segment.
+type entry struct {
+	source  int
+	network string
+	labels  []string
+}
+
+// Merge combines sources into a single route map. For each address, the labels
+// come from the last source that has a network containing it; within a
+// source, the most specific network wins, as in a lookup. Networks in the
+// result do not overlap: where a later source overrides part of a network,
+// the rest of it is split into the fewest networks that cover it exactly.
+// Networks with the same labels are grouped into one segment.
+//
+// The sources must be valid route maps. The overrides are returned in the
+// order they were found.
+func Merge(sources []Source) (*model.RoutemapRoot, []Override, error) {
+	v4, v6 := &trie{bits: 32}, &trie{bits: 128}
+	var overrides []Override
+
+	for idx, src := range sources {
+		prefixes, entries, err := sourceEntries(idx, src.Root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", src.Name, err)
+		}
+
+		for i, p := range prefixes {
+			t := v6
+			if p.Addr().Is4() {
+				t = v4
+			}
+
+			e := entries[i]
+			res := t.insert(p, e)
+
+			override := func(old *entry, partial bool) {
+				overrides = append(overrides, Override{
+					Network:  old.network,
+					Source:   sources[old.source].Name,
+					By:       e.network,
+					BySource: src.Name,
+					Partial:  partial,
+				})
+			}
+
+			if res.partial != nil {
+				override(res.partial, true)
+			}
+			for _, old := range res.replaced {
+				override(old, false)
+			}
+		}
+	}
+
+	root := model.NewRoutemapRoot()
+	segments := make(map[string]int)
+
+	emit := func(p netip.Prefix, e *entry) {
+		key := strings.Join(e.labels, "\x00")
+
+		idx, ok := segments[key]
+		if !ok {
+			idx = len(root.Routemap)
+			segments[key] = idx
+			labels := make([]string, len(e.labels))
+			copy(labels, e.labels)
+			root.Routemap = append(root.Routemap, model.Routemap{Labels: labels})
+		}
+
+		root.Routemap[idx].Networks = append(root.Routemap[idx].Networks, p.String())
+	}
+
+	v4.walk(emit)
+	v6.walk(emit)
+
+	return root, overrides, nil
+}
+
+// sourceEntries returns the networks of root ordered from least to most
+// specific, as trie.insert requires, along with their entries.
+func sourceEntries(source int, root *model.RoutemapRoot) ([]netip.Prefix, []*entry, error) {
+	var (
+		prefixes []netip.Prefix
+		entries  []*entry
+	)
+
+	for _, m := range root.Routemap {
+		for _, network := range m.Networks {
+			p, err := netip.ParsePrefix(network)
+			if err != nil {
+				return nil, nil, fmt.Errorf("network '%s': %v", network, err)
+			} else if p != p.Masked() {
+				return nil, nil, fmt.Errorf("network '%s' is not properly masked", network)
+			}
+
+			prefixes = append(prefixes, p)
+			entries = append(entries, &entry{source: source, network: network, labels: m.Labels})
+		}
+	}
+
+	sort.Stable(byBits{prefixes, entries})
+
+	return prefixes, entries, nil
+}
+
+// byBits orders prefixes, and their entries, by prefix length.
+type byBits struct {
+	prefixes []netip.Prefix
+	entries  []*entry
+}
+
+func (b byBits) Len() int { return len(b.prefixes) }
+
+func (b byBits) Less(i, j int) bool {
+	return b.prefixes[i].Bits() < b.prefixes[j].Bits()
+}
+
+func (b byBits) Swap(i, j int) {
+	b.prefixes[i], b.prefixes[j] = b.prefixes[j], b.prefixes[i]
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/ns1/pulsar-routemap/pkg/lookup"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rootOf(segments ...model.Routemap) *model.RoutemapRoot {
+	root := model.NewRoutemapRoot()
+	root.Routemap = segments
+	return root
+}
+
+func Test_Merge(t *testing.T) {
+	base := rootOf(
+		model.Routemap{Networks: []string{"10.0.0.0/16", "2001:db8::/32"}, Labels: []string{"syd", "mel"}},
+		model.Routemap{Networks: []string{"10.1.0.0/16"}, Labels: []string{"per"}})
+	overrides := rootOf(
+		model.Routemap{Networks: []string{"10.0.1.0/24", "10.1.0.0/17"}, Labels: []string{"bne"}})
+	pins := rootOf(
+		model.Routemap{Networks: []string{"10.1.0.0/16"}, Labels: []string{"adl"}},
+		model.Routemap{Networks: []string{"2001:db8:1::/48"}, Labels: []string{"syd"}})
+
+	merged, overridden, err := Merge([]Source{
+		{Name: "base", Root: base},
+		{Name: "overrides", Root: overrides},
+		{Name: "pins", Root: pins},
+	})
+	require.NoError(t, err)
+
+	_, err = validator.ValidateRoot(merged, validator.Options{})
+	require.NoError(t, err)
+
+	require.Len(t, merged.Routemap, 4)
+	assert.Equal(t, []string{"syd", "mel"}, merged.Routemap[0].Labels)
+	assert.Equal(t, []string{
+		"10.0.0.0/24", "10.0.2.0/23", "10.0.4.0/22", "10.0.8.0/21",
+		"10.0.16.0/20", "10.0.32.0/19", "10.0.64.0/18", "10.0.128.0/17",
+	}, merged.Routemap[0].Networks[:8])
+	assert.Len(t, merged.Routemap[0].Networks, 8+16)
+	assert.Equal(t, model.Routemap{Networks: []string{"10.0.1.0/24"}, Labels: []string{"bne"}}, merged.Routemap[1])
+	assert.Equal(t, model.Routemap{Networks: []string{"10.1.0.0/16"}, Labels: []string{"adl"}}, merged.Routemap[2])
+	assert.Equal(t, model.Routemap{Networks: []string{"2001:db8:1::/48"}, Labels: []string{"syd"}}, merged.Routemap[3])
+
+	var report []string
+	for _, o := range overridden {
+		report = append(report, o.String())
+	}
+
+	assert.Equal(t, []string{
+		"10.1.0.0/16 (base) partly overridden by 10.1.0.0/17 (overrides)",
+		"10.0.0.0/16 (base) partly overridden by 10.0.1.0/24 (overrides)",
+		"10.1.0.0/16 (base) overridden by 10.1.0.0/16 (pins)",
+		"10.1.0.0/17 (overrides) overridden by 10.1.0.0/16 (pins)",
+		"2001:db8::/32 (base) partly overridden by 2001:db8:1::/48 (pins)",
+	}, report)
+}
+
+func Test_Merge_withinSource(t *testing.T) {
+	// Within a source the most specific network wins, and the first of
+	// duplicate networks wins, as in a lookup.
+	src := rootOf(
+		model.Routemap{Networks: []string{"10.0.0.0/24"}, Labels: []string{"narrow"}},
+		model.Routemap{Networks: []string{"10.0.0.0/23", "10.0.0.0/24"}, Labels: []string{"wide"}})
+
+	merged, overridden, err := Merge([]Source{{Name: "a", Root: src}})
+	require.NoError(t, err)
+	assert.Empty(t, overridden)
+	assert.Equal(t, []model.Routemap{
+		{Networks: []string{"10.0.0.0/24"}, Labels: []string{"narrow"}},
+		{Networks: []string{"10.0.1.0/24"}, Labels: []string{"wide"}},
+	}, merged.Routemap)
+}
+
+func Test_Merge_unmasked(t *testing.T) {
+	src := rootOf(model.Routemap{Networks: []string{"10.0.0.1/24"}, Labels: []string{"a"}})
+
+	_, _, err := Merge([]Source{{Name: "a.json", Root: src}})
+	assert.EqualError(t, err, "a.json: network '10.0.0.1/24' is not properly masked")
+}
+
+// Test_Merge_random checks that every address gets the labels of the last
+// source that covers it, and that the merged networks do not overlap.
+func Test_Merge_random(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	randomRoot := func(source int) *model.RoutemapRoot {
+		root := model.NewRoutemapRoot()
+		for s := 0; s < 1+rnd.Intn(4); s++ {
+			m := model.Routemap{Labels: []string{fmt.Sprintf("s%d-%d", source, s)}}
+			for n := 0; n < 1+rnd.Intn(4); n++ {
+				bits := 8 + rnd.Intn(9)
+				ip := net.IPv4(10, byte(rnd.Intn(4)), byte(rnd.Intn(256)), 0).Mask(net.CIDRMask(bits, 32))
+				m.Networks = append(m.Networks, fmt.Sprintf("%s/%d", ip, bits))
+			}
+			root.Routemap = append(root.Routemap, m)
+		}
+		return root
+	}
+
+	for round := 0; round < 50; round++ {
+		var (
+			sources []Source
+			tables  []*lookup.Table
+		)
+
+		for i := 0; i < 1+rnd.Intn(4); i++ {
+			root := randomRoot(i)
+			table, err := lookup.New(root)
+			require.NoError(t, err)
+
+			sources = append(sources, Source{Name: fmt.Sprint(i), Root: root})
+			tables = append(tables, table)
+		}
+
+		merged, _, err := Merge(sources)
+		require.NoError(t, err)
+
+		mergedTable, err := lookup.New(merged)
+		require.NoError(t, err)
+
+		var networks []*net.IPNet
+		for _, m := range merged.Routemap {
+			for _, n := range m.Networks {
+				_, ipnet, err := net.ParseCIDR(n)
+				require.NoError(t, err)
+				networks = append(networks, ipnet)
+			}
+		}
+
+		for probe := 0; probe < 200; probe++ {
+			ip := net.IPv4(10, byte(rnd.Intn(5)), byte(rnd.Intn(256)), byte(rnd.Intn(256)))
+
+			var want []string
+			for _, table := range tables {
+				if m, ok := table.Lookup(ip); ok {
+					want = m.Labels
+				}
+			}
+
+			got, ok := mergedTable.Lookup(ip)
+			assert.Equal(t, want != nil, ok, ip.String())
+			assert.Equal(t, want, got.Labels, ip.String())
+
+			containing := 0
+			for _, ipnet := range networks {
+				if ipnet.Contains(ip) {
+					containing++
+				}
+			}
+			assert.True(t, containing <= 1, "%s is in %d merged networks", ip, containing)
+		}
+	}
+}
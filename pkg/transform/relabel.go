@@ -0,0 +1,239 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/policy"
+)
+
+// RelabelRule renames a label, or drops it if New is empty.
+type RelabelRule struct {
+	Old string
+	New string
+
+	// Source says where the rule came from, for reports, e.g. renames.csv:3.
+	Source string
+}
+
+func (r RelabelRule) String() string {
+	if len(r.New) == 0 {
+		return fmt.Sprintf("drop %s (%s)", r.Old, r.Source)
+	}
+
+	return fmt.Sprintf("%s -> %s (%s)", r.Old, r.New, r.Source)
+}
+
+// LoadRelabelRules reads a CSV file of old,new label pairs. An empty new label
+// drops the old one. An optional first line of old,new is a header; lines
+// starting with '#' are ignored.
+func LoadRelabelRules(filename string) ([]RelabelRule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	var rules []RelabelRule
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parsing mapping file %s: %v", filename, err)
+		}
+
+		line, _ := r.FieldPos(0)
+		from, to := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+
+		if len(rules) == 0 && strings.EqualFold(from, "old") && strings.EqualFold(to, "new") {
+			continue
+		}
+
+		if len(from) == 0 {
+			return nil, fmt.Errorf("mapping file %s: line %d has no old label", filename, line)
+		}
+
+		rules = append(rules, RelabelRule{Old: from, New: to, Source: fmt.Sprintf("%s:%d", filename, line)})
+	}
+
+	return rules, nil
+}
+
+// Relabel rewrites the labels of a route map.
+type Relabel struct {
+	// Rules are applied once to each label, so a rule renaming a to b and
+	// another renaming b to c do not rename a to c. Old labels are compared
+	// without regard to case.
+	Rules []RelabelRule
+
+	// DropEmpty drops segments left without labels, along with their
+	// networks. Otherwise they are an error.
+	DropEmpty bool
+
+	// Fallbacks, if set, completes the fallback labels of each segment after
+	// the rules are applied.
+	Fallbacks *policy.Policy
+}
+
+// RelabelStats counts the changes made by Relabel.Apply.
+type RelabelStats struct {
+	// Rules counts the segments each of Relabel.Rules changed.
+	Rules []int
+
+	SegmentsChanged    int // Segments with a label renamed or dropped.
+	FallbacksCompleted int // Segments with fallback labels added or moved by Relabel.Fallbacks.
+	LabelsDeduplicated int // Labels removed as a renamed label was already in the segment.
+	SegmentsMerged     int // Segments merged into an earlier one with the same labels.
+	SegmentsDropped    int // Segments left without labels, if Relabel.DropEmpty.
+	NetworksDropped    int // Networks of the dropped segments.
+}
+
+// Validate checks that no two rules give different outcomes for a label.
+func (r *Relabel) Validate() error {
+	byOld := make(map[string]RelabelRule)
+
+	for _, rule := range r.Rules {
+		if len(rule.Old) == 0 {
+			return fmt.Errorf("rule %s has no old label", rule)
+		}
+
+		key := strings.ToLower(rule.Old)
+		if prev, ok := byOld[key]; ok && prev.New != rule.New {
+			return fmt.Errorf("conflicting rules for label '%s': %s and %s", rule.Old, prev, rule)
+		} else if !ok {
+			byOld[key] = rule
+		}
+	}
+
+	return nil
+}
+
+// Apply rewrites the labels of each segment of root, keeping their order.
+// Where a renamed label duplicates one already in the segment, only the first
+// is kept. Fallbacks are then completed, if required. Segments that end up with the same labels are merged into the first
+// of them.
+func (r *Relabel) Apply(root *model.RoutemapRoot) (*model.RoutemapRoot, RelabelStats, error) {
+	stats := RelabelStats{Rules: make([]int, len(r.Rules))}
+
+	if err := r.Validate(); err != nil {
+		return nil, stats, err
+	}
+
+	index := make(map[string]int)
+	for i := len(r.Rules) - 1; i >= 0; i-- {
+		index[strings.ToLower(r.Rules[i].Old)] = i
+	}
+
+	out := model.NewRoutemapRoot()
+	out.Meta = root.Meta
+
+	var (
+		segments = make(map[string]int)
+		merged   = make(map[int]bool)
+	)
+
+	for idx, m := range root.Routemap {
+		labels := make([]string, 0, len(m.Labels))
+		changed := false
+
+		for _, l := range m.Labels {
+			if i, ok := index[strings.ToLower(l)]; ok {
+				stats.Rules[i]++
+				changed = true
+
+				if len(r.Rules[i].New) == 0 {
+					continue
+				}
+				l = r.Rules[i].New
+			}
+
+			if hasLabel(labels, l) {
+				stats.LabelsDeduplicated++
+				continue
+			}
+
+			labels = append(labels, l)
+		}
+
+		if changed {
+			stats.SegmentsChanged++
+		}
+
+		if r.Fallbacks != nil {
+			var completed bool
+			if labels, completed = r.Fallbacks.Complete(labels); completed {
+				stats.FallbacksCompleted++
+			}
+		}
+
+		if len(labels) == 0 {
+			if !r.DropEmpty {
+				return nil, stats, fmt.Errorf("map segment index=%d would be left without labels", idx)
+			}
+
+			stats.SegmentsDropped++
+			stats.NetworksDropped += len(m.Networks)
+			continue
+		}
+
+		key := strings.Join(labels, "\x00")
+		if i, ok := segments[key]; ok {
+			out.Routemap[i].Networks = append(out.Routemap[i].Networks, m.Networks...)
+			merged[i] = true
+			stats.SegmentsMerged++
+			continue
+		}
+
+		networks := make([]string, len(m.Networks))
+		copy(networks, m.Networks)
+
+		segments[key] = len(out.Routemap)
+		out.Routemap = append(out.Routemap, model.Routemap{Networks: networks, Labels: labels})
+	}
+
+	// Merged segments may have networks in common.
+	for i := range merged {
+		out.Routemap[i].Networks = dedupeStrings(out.Routemap[i].Networks)
+	}
+
+	return out, stats, nil
+}
+
+// dedupeStrings removes repeats from values, keeping the first of each.
+func dedupeStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := values[:0]
+
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Relabel(t *testing.T) {
+	root := rootOf(
+		model.Routemap{Networks: []string{"10.0.0.0/24"}, Labels: []string{"syd", "mel", "per"}},
+		model.Routemap{Networks: []string{"10.0.1.0/24", "10.0.2.0/24"}, Labels: []string{"SYDNEY", "mel"}},
+		model.Routemap{Networks: []string{"10.0.0.0/24", "10.0.3.0/24"}, Labels: []string{"sydney", "per", "mel"}},
+		model.Routemap{Networks: []string{"10.0.4.0/24"}, Labels: []string{"hkg", "mel"}})
+
+	r := &Relabel{Rules: []RelabelRule{
+		{Old: "SYD", New: "sydney", Source: "a"},
+		{Old: "per", Source: "b"},
+		{Old: "mel", New: "syd", Source: "c"},
+		{Old: "adl", New: "per", Source: "d"},
+	}}
+
+	out, stats, err := r.Apply(root)
+	require.NoError(t, err)
+
+	assert.Equal(t, []model.Routemap{
+		{Networks: []string{"10.0.0.0/24", "10.0.3.0/24"}, Labels: []string{"sydney", "syd"}},
+		{Networks: []string{"10.0.1.0/24", "10.0.2.0/24"}, Labels: []string{"SYDNEY", "syd"}},
+		{Networks: []string{"10.0.4.0/24"}, Labels: []string{"hkg", "syd"}},
+	}, out.Routemap)
+
+	assert.Equal(t, RelabelStats{
+		Rules:              []int{1, 2, 4, 0},
+		SegmentsChanged:    4,
+		LabelsDeduplicated: 0,
+		SegmentsMerged:     1,
+	}, stats)
+
+	// The input is unchanged.
+	assert.Equal(t, []string{"10.0.1.0/24", "10.0.2.0/24"}, root.Routemap[1].Networks)
+}
+
+func Test_Relabel_deduplicate(t *testing.T) {
+	root := rootOf(model.Routemap{Networks: []string{"10.0.0.0/24"}, Labels: []string{"syd", "mel", "sydney"}})
+
+	out, stats, err := (&Relabel{Rules: []RelabelRule{{Old: "sydney", New: "SYD"}}}).Apply(root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"syd", "mel"}, out.Routemap[0].Labels)
+	assert.Equal(t, 1, stats.LabelsDeduplicated)
+}
+
+func Test_Relabel_fallbacks(t *testing.T) {
+	p, err := policy.New(policy.File{Fallbacks: map[string][]string{"syd": {"apac", "global"}}})
+	require.NoError(t, err)
+
+	root := rootOf(
+		model.Routemap{Networks: []string{"10.0.0.0/24"}, Labels: []string{"sydney", "global"}},
+		model.Routemap{Networks: []string{"10.0.1.0/24"}, Labels: []string{"syd", "apac", "global"}},
+		model.Routemap{Networks: []string{"10.0.2.0/24"}, Labels: []string{"hkg"}})
+
+	r := &Relabel{Rules: []RelabelRule{{Old: "sydney", New: "syd"}}, Fallbacks: p}
+	out, stats, err := r.Apply(root)
+	require.NoError(t, err)
+
+	assert.Equal(t, []model.Routemap{
+		{Networks: []string{"10.0.0.0/24", "10.0.1.0/24"}, Labels: []string{"syd", "apac", "global"}},
+		{Networks: []string{"10.0.2.0/24"}, Labels: []string{"hkg"}},
+	}, out.Routemap)
+	assert.Equal(t, 1, stats.FallbacksCompleted)
+	assert.Equal(t, 1, stats.SegmentsMerged)
+}
+
+func Test_Relabel_empty(t *testing.T) {
+	root := rootOf(
+		model.Routemap{Networks: []string{"10.0.0.0/24"}, Labels: []string{"per"}},
+		model.Routemap{Networks: []string{"10.0.1.0/24"}, Labels: []string{"syd"}})
+
+	r := &Relabel{Rules: []RelabelRule{{Old: "per"}}}
+	_, _, err := r.Apply(root)
+	assert.EqualError(t, err, "map segment index=0 would be left without labels")
+
+	r.DropEmpty = true
+	out, stats, err := r.Apply(root)
+	require.NoError(t, err)
+	assert.Len(t, out.Routemap, 1)
+	assert.Equal(t, 1, stats.SegmentsDropped)
+	assert.Equal(t, 1, stats.NetworksDropped)
+}
+
+func Test_Relabel_conflict(t *testing.T) {
+	r := &Relabel{Rules: []RelabelRule{
+		{Old: "syd", New: "sydney", Source: "--map"},
+		{Old: "SYD", New: "sydney", Source: "renames.csv:1"},
+		{Old: "Syd", Source: "renames.csv:2"},
+	}}
+
+	assert.EqualError(t, r.Validate(),
+		"conflicting rules for label 'Syd': syd -> sydney (--map) and drop Syd (renames.csv:2)")
+}
+
+func Test_LoadRelabelRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relabel")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "renames.csv")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("old,new\n# Retired POPs\nsyd, sydney\nper,\n"), 0644))
+
+	rules, err := LoadRelabelRules(filename)
+	require.NoError(t, err)
+	assert.Equal(t, []RelabelRule{
+		{Old: "syd", New: "sydney", Source: filename + ":3"},
+		{Old: "per", New: "", Source: filename + ":4"},
+	}, rules)
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("syd\n"), 0644))
+	_, err = LoadRelabelRules(filename)
+	assert.Error(t, err)
+}
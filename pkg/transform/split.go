@@ -0,0 +1,306 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+)
+
+// Ways of grouping segments before a map is split to fit its limits.
+const (
+	SplitByNone   = ""
+	SplitByFamily = "family"
+	SplitByLabel  = "label"
+)
+
+// SplitOptions control how Split partitions a route map.
+type SplitOptions struct {
+	// Limits that each part must be within. A zero limit is not checked.
+	Limits model.Limits
+
+	// By keeps IPv4 and IPv6 networks (SplitByFamily), or segments with
+	// different primary labels (SplitByLabel), in separate parts.
+	By string
+}
+
+func (o SplitOptions) Validate() error {
+	switch o.By {
+	case SplitByNone, SplitByFamily, SplitByLabel:
+	default:
+		return fmt.Errorf("invalid split mode '%s'; must be family or label", o.By)
+	}
+
+	if o.Limits.MaxSegments < 0 || o.Limits.MaxSizeBytes < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+
+	return nil
+}
+
+// Part is one of the route maps produced by Split.
+type Part struct {
+	// Key is the address family or primary label of the part's group, or
+	// empty if segments were not grouped.
+	Key  string
+	Root *model.RoutemapRoot
+}
+
+// Split partitions root into route maps that are each within opts.Limits
+// when written with WriteTo. Every network of root is in exactly one part,
+// with the labels of its segment. Segments are kept whole unless one is too
+// big for a part on its own, in which case its networks are spread over
+// several segments with the same labels. Parts are in the order of root's
+// segments, grouped as opts.By requires.
+func Split(root *model.RoutemapRoot, opts SplitOptions) ([]Part, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	meta, err := json.Marshal(root.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []Part
+
+	for _, g := range groupSegments(root.Routemap, opts.By) {
+		p := &packer{
+			limits:   opts.Limits,
+			meta:     root.Meta,
+			overhead: len(`{"meta":`) + len(meta) + len(`,"map":[`+"\n") + len("]}\n"),
+			key:      g.key,
+		}
+
+		for _, m := range g.segments {
+			if err = p.add(m); err != nil {
+				return nil, err
+			}
+		}
+
+		p.flush()
+		parts = append(parts, p.parts...)
+	}
+
+	return parts, nil
+}
+
+type segmentGroup struct {
+	key      string
+	segments []model.Routemap
+}
+
+// groupSegments separates segments into groups, in order of first appearance.
+func groupSegments(segments []model.Routemap, by string) []segmentGroup {
+	switch by {
+	case SplitByFamily:
+		v4 := segmentGroup{key: "ipv4"}
+		v6 := segmentGroup{key: "ipv6"}
+
+		for _, m := range segments {
+			var nets4, nets6 []string
+			for _, n := range m.Networks {
+				if p, err := netip.ParsePrefix(n); err == nil && p.Addr().Is4() {
+					nets4 = append(nets4, n)
+				} else {
+					nets6 = append(nets6, n)
+				}
+			}
+
+			if len(nets4) > 0 {
+				v4.segments = append(v4.segments, model.Routemap{Networks: nets4, Labels: m.Labels})
+			}
+			if len(nets6) > 0 {
+				v6.segments = append(v6.segments, model.Routemap{Networks: nets6, Labels: m.Labels})
+			}
+		}
+
+		var groups []segmentGroup
+		for _, g := range []segmentGroup{v4, v6} {
+			if len(g.segments) > 0 {
+				groups = append(groups, g)
+			}
+		}
+		return groups
+
+	case SplitByLabel:
+		var groups []segmentGroup
+		index := make(map[string]int)
+
+		for _, m := range segments {
+			var primary string
+			if len(m.Labels) > 0 {
+				primary = m.Labels[0]
+			}
+
+			idx, ok := index[primary]
+			if !ok {
+				idx = len(groups)
+				index[primary] = idx
+				groups = append(groups, segmentGroup{key: primary})
+			}
+
+			groups[idx].segments = append(groups[idx].segments, m)
+		}
+		return groups
+
+	default:
+		return []segmentGroup{{segments: segments}}
+	}
+}
+
+// packer fills parts with segments, in order, up to the limits.
+type packer struct {
+	limits   model.Limits
+	meta     map[string]interface{}
+	overhead int // Bytes of a part without segments.
+	key      string
+
+	parts []Part
+	cur   []model.Routemap
+	size  int
+}
+
+func (p *packer) fits(segmentSize int) bool {
+	if p.limits.MaxSegments > 0 && len(p.cur)+1 > p.limits.MaxSegments {
+		return false
+	}
+
+	return p.limits.MaxSizeBytes == 0 || p.size+p.separator()+segmentSize <= p.limits.MaxSizeBytes
+}
+
+// separator is the size of the comma before the next segment.
+func (p *packer) separator() int {
+	if len(p.cur) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (p *packer) flush() {
+	if len(p.cur) == 0 {
+		return
+	}
+
+	root := model.NewRoutemapRoot()
+	root.Meta = p.meta
+	root.Routemap = p.cur
+
+	p.parts = append(p.parts, Part{Key: p.key, Root: root})
+	p.cur = nil
+	p.size = p.overhead
+}
+
+func (p *packer) add(m model.Routemap) error {
+	if len(p.cur) == 0 {
+		p.size = p.overhead
+	}
+
+	labelsSize := segmentOverhead(m.Labels)
+	netSizes := make([]int, len(m.Networks))
+	size := labelsSize
+	for i, n := range m.Networks {
+		netSizes[i] = jsonStringLen(n)
+		size += netSizes[i]
+	}
+
+	switch {
+	case len(m.Networks) > 1:
+		size += len(m.Networks) - 1 // Commas between networks.
+	case m.Networks == nil:
+		size += len("null") - len("[]")
+	}
+
+	// Start a new part rather than split a segment that fits in one.
+	if !p.fits(size) && len(p.cur) > 0 && (p.limits.MaxSizeBytes == 0 || p.overhead+size <= p.limits.MaxSizeBytes) {
+		p.flush()
+	}
+
+	if p.fits(size) {
+		p.size += p.separator() + size
+		p.cur = append(p.cur, m)
+		return nil
+	}
+
+	if len(m.Networks) == 0 {
+		return fmt.Errorf("segment with labels %s and no networks does not fit in a map of %d bytes",
+			strings.Join(m.Labels, ","), p.limits.MaxSizeBytes)
+	}
+
+	// The segment is bigger than a part, so spread its networks over as
+	// few parts as possible.
+	for start := 0; start < len(m.Networks); {
+		end, chunkSize := start, labelsSize-1
+		for end < len(m.Networks) && p.fits(chunkSize+1+netSizes[end]) {
+			chunkSize += 1 + netSizes[end]
+			end++
+		}
+
+		if end == start {
+			if len(p.cur) == 0 {
+				return fmt.Errorf("network %s with labels %s does not fit in a map of %d bytes",
+					m.Networks[start], strings.Join(m.Labels, ","), p.limits.MaxSizeBytes)
+			}
+
+			p.flush()
+			continue
+		}
+
+		p.size += p.separator() + chunkSize
+		p.cur = append(p.cur, model.Routemap{Networks: m.Networks[start:end], Labels: m.Labels})
+		start = end
+
+		if start < len(m.Networks) {
+			p.flush()
+		}
+	}
+
+	return nil
+}
+
+// segmentOverhead is the size of a segment with labels as written by WriteTo,
+// excluding its networks and the commas between them.
+func segmentOverhead(labels []string) int {
+	size := len(`{"networks":[],"labels":[]}` + "\n")
+	for _, l := range labels {
+		size += jsonStringLen(l)
+	}
+
+	switch {
+	case len(labels) > 1:
+		size += len(labels) - 1
+	case labels == nil:
+		size += len("null") - len("[]")
+	}
+
+	return size
+}
+
+// jsonStringLen is the length of s encoded as a JSON string.
+func jsonStringLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= 0x7f || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			b, _ := json.Marshal(s)
+			return len(b)
+		}
+	}
+
+	return len(s) + 2
+}
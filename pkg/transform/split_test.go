@@ -0,0 +1,165 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ns1/pulsar-routemap/pkg/generate"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generatedRoot(t *testing.T, segments int) *model.RoutemapRoot {
+	var buf bytes.Buffer
+	_, err := generate.Write(&buf, generate.Options{
+		Segments: segments, NetworksPerSegment: 5, V6Ratio: 0.3, Labels: 5, Seed: 1,
+	})
+	require.NoError(t, err)
+
+	root, err := model.LoadRoutemap(&buf)
+	require.NoError(t, err)
+	return root
+}
+
+func encodedSize(t *testing.T, root *model.RoutemapRoot) int {
+	n, err := root.WriteTo(ioutil.Discard)
+	require.NoError(t, err)
+	return int(n)
+}
+
+// checkParts verifies that every network of root is in exactly one part, with
+// the same labels, and that the parts are within limits.
+func checkParts(t *testing.T, root *model.RoutemapRoot, parts []Part, limits model.Limits) {
+	want := make(map[string]int)
+	for _, m := range root.Routemap {
+		for _, n := range m.Networks {
+			want[fmt.Sprint(n, m.Labels)]++
+		}
+	}
+
+	got := make(map[string]int)
+	for _, p := range parts {
+		for _, m := range p.Root.Routemap {
+			for _, n := range m.Networks {
+				got[fmt.Sprint(n, m.Labels)]++
+			}
+		}
+
+		if limits.MaxSegments > 0 {
+			assert.True(t, len(p.Root.Routemap) <= limits.MaxSegments)
+		}
+		if limits.MaxSizeBytes > 0 {
+			assert.True(t, encodedSize(t, p.Root) <= limits.MaxSizeBytes)
+		}
+	}
+
+	assert.Equal(t, want, got)
+}
+
+func Test_Split(t *testing.T) {
+	root := generatedRoot(t, 200)
+
+	for _, limits := range []model.Limits{
+		{MaxSegments: 30},
+		{MaxSizeBytes: 1000},
+		{MaxSegments: 7, MaxSizeBytes: 500},
+		{MaxSizeBytes: 120}, // Smaller than most segments.
+	} {
+		for _, by := range []string{SplitByNone, SplitByFamily, SplitByLabel} {
+			parts, err := Split(root, SplitOptions{Limits: limits, By: by})
+			require.NoError(t, err)
+			checkParts(t, root, parts, limits)
+		}
+	}
+}
+
+func Test_Split_exactSize(t *testing.T) {
+	root := generatedRoot(t, 20)
+	size := encodedSize(t, root)
+
+	parts, err := Split(root, SplitOptions{Limits: model.Limits{MaxSizeBytes: size}})
+	require.NoError(t, err)
+	assert.Len(t, parts, 1)
+
+	parts, err = Split(root, SplitOptions{Limits: model.Limits{MaxSizeBytes: size - 1}})
+	require.NoError(t, err)
+	assert.Len(t, parts, 2)
+}
+
+// Segments without networks are sized exactly too.
+func Test_Split_exactSizeEmptySegment(t *testing.T) {
+	for _, empty := range []model.Routemap{
+		{Networks: []string{}, Labels: []string{"mel"}},
+		{Networks: nil, Labels: []string{"mel", "syd"}},
+		{Networks: []string{}, Labels: nil},
+	} {
+		root := rootOf(model.Routemap{Networks: []string{"10.0.0.0/24"}, Labels: []string{"syd"}}, empty)
+		size := encodedSize(t, root)
+
+		limits := model.Limits{MaxSizeBytes: size}
+		parts, err := Split(root, SplitOptions{Limits: limits})
+		require.NoError(t, err)
+		assert.Len(t, parts, 1)
+
+		limits.MaxSizeBytes = size - 1
+		parts, err = Split(root, SplitOptions{Limits: limits})
+		require.NoError(t, err)
+		assert.Len(t, parts, 2)
+		checkParts(t, root, parts, limits)
+	}
+}
+
+func Test_Split_groups(t *testing.T) {
+	root := rootOf(
+		model.Routemap{Networks: []string{"10.0.0.0/24", "2001:db8::/48"}, Labels: []string{"syd", "mel"}},
+		model.Routemap{Networks: []string{"10.0.1.0/24"}, Labels: []string{"mel"}},
+		model.Routemap{Networks: []string{"10.0.2.0/24"}, Labels: []string{"syd"}})
+
+	parts, err := Split(root, SplitOptions{By: SplitByFamily})
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+	assert.Equal(t, "ipv4", parts[0].Key)
+	assert.Len(t, parts[0].Root.Routemap, 3)
+	assert.Equal(t, "ipv6", parts[1].Key)
+	assert.Equal(t, []model.Routemap{{Networks: []string{"2001:db8::/48"}, Labels: []string{"syd", "mel"}}},
+		parts[1].Root.Routemap)
+
+	parts, err = Split(root, SplitOptions{By: SplitByLabel})
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+	assert.Equal(t, "syd", parts[0].Key)
+	assert.Len(t, parts[0].Root.Routemap, 2)
+	assert.Equal(t, "mel", parts[1].Key)
+	assert.Len(t, parts[1].Root.Routemap, 1)
+}
+
+func Test_Split_tooSmall(t *testing.T) {
+	root := rootOf(model.Routemap{Networks: []string{"10.0.0.0/24"}, Labels: []string{"syd"}})
+
+	_, err := Split(root, SplitOptions{Limits: model.Limits{MaxSizeBytes: 50}})
+	assert.EqualError(t, err, "network 10.0.0.0/24 with labels syd does not fit in a map of 50 bytes")
+
+	root = rootOf(model.Routemap{Networks: []string{}, Labels: []string{"syd", "mel"}})
+	_, err = Split(root, SplitOptions{Limits: model.Limits{MaxSizeBytes: 50}})
+	assert.EqualError(t, err, "segment with labels syd,mel and no networks does not fit in a map of 50 bytes")
+
+	_, err = Split(root, SplitOptions{By: "colour"})
+	assert.EqualError(t, err, "invalid split mode 'colour'; must be family or label")
+}
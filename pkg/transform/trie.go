@@ -0,0 +1,165 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transform
+
+import (
+	"net/netip"
+)
+
+// trieNode is a node of a binary trie of prefixes of one address family.
+// A node covers the prefix spelled by the path to it; if val is set, every
+// address in that prefix not covered by a deeper node with a value maps to
+// val (longest prefix match).
+type trieNode struct {
+	child [2]*trieNode
+	val   *entry
+}
+
+// trie holds the networks of one address family.
+type trie struct {
+	root trieNode
+	bits int // 32 for IPv4, 128 for IPv6
+}
+
+// addrBit returns bit i, counting from the most significant, of a.
+func addrBit(a netip.Addr, i int) int {
+	b := a.As16()
+	if a.Is4() {
+		i += 96
+	}
+
+	return int(b[i/8]>>(7-uint(i%8))) & 1
+}
+
+// insertResult describes what an insert displaced.
+type insertResult struct {
+	// partial is the less specific entry, if any, that the new prefix carves
+	// a hole in.
+	partial *entry
+
+	// replaced are the entries at or under the new prefix that it removes.
+	replaced []*entry
+
+	// duplicate is set when the same source already had this prefix, in
+	// which case nothing is changed.
+	duplicate bool
+}
+
+// insert maps p to e, replacing anything from an earlier source at or under p.
+// Within a source, networks must be inserted from least to most specific so
+// that a more specific network only refines its own source's less specific
+// ones.
+func (t *trie) insert(p netip.Prefix, e *entry) insertResult {
+	var (
+		res       insertResult
+		inherited *entry
+	)
+
+	n := &t.root
+	for i := 0; i < p.Bits(); i++ {
+		if n.val != nil {
+			inherited = n.val
+		}
+
+		b := addrBit(p.Addr(), i)
+		if n.child[b] == nil {
+			n.child[b] = &trieNode{}
+		}
+		n = n.child[b]
+	}
+
+	if n.val != nil && n.val.source == e.source {
+		res.duplicate = true
+		return res
+	}
+
+	if inherited != nil && inherited.source != e.source {
+		res.partial = inherited
+	}
+
+	collect(n, &res.replaced)
+
+	n.val = e
+	n.child = [2]*trieNode{}
+
+	return res
+}
+
+// collect appends the values at and under n to out.
+func collect(n *trieNode, out *[]*entry) {
+	if n == nil {
+		return
+	}
+
+	if n.val != nil {
+		*out = append(*out, n.val)
+	}
+
+	collect(n.child[0], out)
+	collect(n.child[1], out)
+}
+
+// walk calls fn, in address order, with a set of non-overlapping prefixes that
+// exactly cover the addresses mapped by the trie. Where a more specific prefix
+// carves a hole in a less specific one, the remainder of the less specific
+// prefix is split into the fewest prefixes that cover it.
+func (t *trie) walk(fn func(netip.Prefix, *entry)) {
+	var addr [16]byte
+	if t.bits == 32 {
+		// IPv4 addresses are built in their IPv4-mapped IPv6 form.
+		addr[10], addr[11] = 0xff, 0xff
+	}
+
+	t.walkNode(&t.root, addr, 0, nil, fn)
+}
+
+func (t *trie) walkNode(n *trieNode, addr [16]byte, depth int, inherited *entry, fn func(netip.Prefix, *entry)) {
+	if n.val != nil {
+		inherited = n.val
+	}
+
+	if n.child[0] == nil && n.child[1] == nil {
+		if inherited != nil {
+			fn(t.prefix(addr, depth), inherited)
+		}
+		return
+	}
+
+	for b := 0; b < 2; b++ {
+		next := addr
+		if b == 1 {
+			i := depth
+			if t.bits == 32 {
+				i += 96
+			}
+			next[i/8] |= 1 << (7 - uint(i%8))
+		}
+
+		if n.child[b] != nil {
+			t.walkNode(n.child[b], next, depth+1, inherited, fn)
+		} else if inherited != nil {
+			fn(t.prefix(next, depth+1), inherited)
+		}
+	}
+}
+
+func (t *trie) prefix(addr [16]byte, bits int) netip.Prefix {
+	a := netip.AddrFrom16(addr)
+	if t.bits == 32 {
+		a = a.Unmap()
+	}
+
+	return netip.PrefixFrom(a, bits)
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validator
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/ns1/pulsar-routemap/pkg/generate"
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	benchMapOnce sync.Once
+	benchMapData []byte
+)
+
+// benchMap returns a generated map of 20,000 segments and 200,000 networks,
+// a fifth of them IPv6.
+func benchMap(b *testing.B) []byte {
+	benchMapOnce.Do(func() {
+		buf := &bytes.Buffer{}
+		_, err := generate.Write(buf, generate.Options{
+			Segments:           20000,
+			NetworksPerSegment: 10,
+			V6Ratio:            0.2,
+			Labels:             50,
+			Seed:               1,
+		})
+		require.NoError(b, err)
+		benchMapData = buf.Bytes()
+	})
+
+	return benchMapData
+}
+
+func benchRoot(b *testing.B) *model.RoutemapRoot {
+	root, err := model.LoadRoutemap(bytes.NewReader(benchMap(b)))
+	require.NoError(b, err)
+	return root
+}
+
+func BenchmarkValidateNetwork(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := ValidateNetwork("192.168.22.0/24"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkValidateNetworks measures the per-network cost of validation,
+// including the summary. A new summary is started for each pass over the map,
+// as a summary keeps its networks until it is finished.
+func BenchmarkValidateNetworks(b *testing.B) {
+	root := benchRoot(b)
+	summary := model.NewRoutemapSummary()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if i%len(root.Routemap) == 0 {
+			summary = model.NewRoutemapSummary()
+		}
+
+		m := root.Routemap[i%len(root.Routemap)]
+		if err := ValidateNetworks(m.Networks, 0, &summary); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidateRoot(b *testing.B) {
+	root := benchRoot(b)
+	b.SetBytes(int64(root.SizeInBytes))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ValidateRoot(root, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadAndValidate(b *testing.B) {
+	data := benchMap(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		root, err := model.LoadRoutemap(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := ValidateRoot(root, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
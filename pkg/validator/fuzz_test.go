@@ -0,0 +1,177 @@
+// Copyright 2020 NSONE, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validator
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/multierr"
+)
+
+// FuzzValidateNetwork checks that adversarial network strings never cause a
+// panic and that accepted networks are canonical and within the limits. The
+// seed corpus is in testdata/fuzz/FuzzValidateNetwork.
+func FuzzValidateNetwork(f *testing.F) {
+	for _, n := range []string{"192.168.22.0/24", "2001:db8:1234::/48", "192.168.22.22/24", "::ffff:10.0.0.0/104"} {
+		f.Add(n)
+	}
+
+	f.Fuzz(func(t *testing.T, network string) {
+		ip, ipnet, err := ValidateNetwork(network)
+		if ipnet == nil {
+			assert.Error(t, err)
+			return
+		}
+
+		if err != nil {
+			return
+		}
+
+		assert.True(t, ip.Equal(ipnet.IP))
+
+		ones, bits := ipnet.Mask.Size()
+		if bits == 32 {
+			assert.LessOrEqual(t, ones, model.MaxNetworkBitsV4)
+		} else {
+			assert.LessOrEqual(t, ones, model.MaxNetworkBitsV6)
+		}
+
+		// The canonical form of an accepted network is also accepted.
+		_, again, err := ValidateNetwork(ipnet.String())
+		require.NoError(t, err, ipnet.String())
+		assert.Equal(t, ipnet.String(), again.String())
+	})
+}
+
+// FuzzValidateLabels checks that accepted labels are non-blank, ASCII-only and
+// unique regardless of case. Labels are separated by NUL bytes in the input.
+// The seed corpus is in testdata/fuzz/FuzzValidateLabels.
+func FuzzValidateLabels(f *testing.F) {
+	for _, labels := range []string{"syd", "syd\x00mel", "a\x00A", " ", "café", ""} {
+		f.Add(labels)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		labels := strings.Split(input, "\x00")
+		summary := model.NewRoutemapSummary()
+
+		if err := ValidateLabels(labels, 0, &summary); err != nil {
+			return
+		}
+
+		seen := map[string]bool{}
+		for _, lbl := range labels {
+			assert.NotEmpty(t, strings.TrimSpace(lbl))
+			assert.True(t, isAsciiOnly(lbl), lbl)
+
+			lc := strings.ToLower(lbl)
+			assert.False(t, seen[lc], lbl)
+			seen[lc] = true
+
+			assert.Equal(t, 1, summary.LabelDistribution[lbl])
+		}
+	})
+}
+
+// ValidateNetwork must agree with the standard library on what parses.
+func Test_ValidateNetwork_unparsable(t *testing.T) {
+	for _, n := range []string{"", "/", "10.0.0.0", "10.0.0.0/", "10.0.0.0/-1", "300.0.0.0/8",
+		"10.0.0.0/8/8", "::/129", "1.2.3.4/24\x00", " 10.0.0.0/8"} {
+		_, _, parseErr := net.ParseCIDR(n)
+		require.Error(t, parseErr, n)
+
+		_, ipnet, err := ValidateNetwork(n)
+		assert.Nil(t, ipnet, n)
+		assert.Equal(t, errUnparsableNetworkAddr, err, n)
+	}
+}
+
+// FuzzValidPrefix is a differential test of the fast path of ValidateNetwork
+// and ValidateNetworks against the full checks: whatever the fast path
+// accepts, they must accept as the same network. The seed corpus is in
+// testdata/fuzz/FuzzValidPrefix.
+func FuzzValidPrefix(f *testing.F) {
+	for _, n := range []string{"192.168.22.0/24", "2001:db8:1234::/48", "1.2.3.0/024", "1.2.3.0/+24",
+		"::ffff:1.2.3.0/120", "::/0", "0.0.0.0/0", "1.2.3.0/24 "} {
+		f.Add(n)
+	}
+
+	f.Fuzz(func(t *testing.T, network string) {
+		p, ok := validPrefix(network)
+		if !ok {
+			return
+		}
+
+		ip, ipnet, err := validateCIDR(network)
+		require.NoError(t, err, network)
+		assert.Equal(t, ipnet.String(), p.String(), network)
+
+		ones, bits := ipnet.Mask.Size()
+		assert.Equal(t, ones, p.Bits(), network)
+		assert.Equal(t, bits, p.Addr().BitLen(), network)
+
+		fastIP, fastIPNet, err := ValidateNetwork(network)
+		require.NoError(t, err, network)
+		assert.Equal(t, ip, fastIP, network)
+		assert.Equal(t, ipnet, fastIPNet, network)
+	})
+}
+
+// The fast path must produce the same errors and summary as validating each
+// network with ValidateNetwork.
+func Test_ValidateNetworks_sameAsValidateNetwork(t *testing.T) {
+	nets := []string{"192.168.22.0/24", "192.168.22.22/24", "10.0.0.0/27", "2001:db8::/48",
+		"2001:db8::1/48", "2001:db8::/96", "::ffff:10.0.0.0/104", "1.2.3.0/024", "bogus", "0.0.0.0/0", "::/0"}
+
+	fast := model.NewRoutemapSummary()
+	fastErr := ValidateNetworks(nets, 3, &fast)
+
+	slow := model.NewRoutemapSummary()
+	slow.NumNetworks = len(nets)
+	var slowErrs []string
+	for idx, n := range nets {
+		_, ipnet, err := ValidateNetwork(n)
+		for _, e := range multierr.Errors(err) {
+			slowErrs = append(slowErrs, fmt.Sprintf("%v (for CIDR \"%s\" at index=%d, map segment index=3)", e, n, idx))
+		}
+		if err == nil {
+			slow.SummarizeNetwork(ipnet)
+		}
+	}
+
+	var fastErrs []string
+	for _, e := range multierr.Errors(fastErr) {
+		fastErrs = append(fastErrs, e.Error())
+	}
+
+	assert.Equal(t, slowErrs, fastErrs)
+	assert.Equal(t, slow.NumIPv4, fast.NumIPv4)
+	assert.Equal(t, slow.NumIPv6, fast.NumIPv6)
+	assert.Equal(t, slow.IPv4PrefixLengths, fast.IPv4PrefixLengths)
+	assert.Equal(t, slow.IPv6PrefixLengths, fast.IPv6PrefixLengths)
+
+	slow.Finish()
+	fast.Finish()
+	assert.Equal(t, slow.Coverage.IPv4Addrs.String(), fast.Coverage.IPv4Addrs.String())
+	assert.Equal(t, slow.Coverage.IPv6Nets64.String(), fast.Coverage.IPv6Nets64.String())
+	assert.Equal(t, slow.Coverage.IPv4AddrsSum.String(), fast.Coverage.IPv4AddrsSum.String())
+	assert.Equal(t, slow.Coverage.IPv6Nets64Sum.String(), fast.Coverage.IPv6Nets64Sum.String())
+}
@@ -18,31 +18,69 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
 	"unicode"
 
 	"github.com/ns1/pulsar-routemap/pkg/lg"
 	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/policy"
 	"go.uber.org/multierr"
 )
 
 var errUnparsableNetworkAddr = errors.New("unparsable network address")
 
+// Options are optional checks made in addition to the standard validation.
+type Options struct {
+	// Limits are checked if set.
+	Limits model.Limits
+
+	// Policy, if set, is checked for the labels of each map segment.
+	Policy *policy.Policy
+
+	// Vocabulary, if set, is the set of labels that map segments may use.
+	Vocabulary *policy.Vocabulary
+
+	// CheckLabelCase reports labels written with different case in different
+	// map segments, e.g. SYD and syd.
+	CheckLabelCase bool
+}
+
 func LoadAndValidate(filename string) (*model.RoutemapRoot, model.RoutemapSummary, error) {
-	var (
-		rmap    *model.RoutemapRoot
-		summary = model.NewRoutemapSummary()
-		err     error
-	)
+	return LoadAndValidateWithOptions(filename, Options{})
+}
 
-	if rmap, err = model.LoadRoutemapFileOrStdin(filename); err != nil {
-		return nil, summary, err
+// LoadAndValidateWithOptions loads the named route map (or STDIN) and validates
+// it, including any extra checks in opts.
+func LoadAndValidateWithOptions(filename string, opts Options) (*model.RoutemapRoot, model.RoutemapSummary, error) {
+	rmap, err := model.LoadRoutemapFileOrStdin(filename)
+	if err != nil {
+		return nil, model.NewRoutemapSummary(), err
 	}
 
-	err = startValidate(rmap, &summary)
+	summary, err := ValidateRoot(rmap, opts)
 	return rmap, summary, err
 }
 
+// ValidateRoot validates an already loaded route map, including any extra
+// checks in opts. The summary is filled in even when there are errors.
+func ValidateRoot(rmap *model.RoutemapRoot, opts Options) (model.RoutemapSummary, error) {
+	summary := model.NewRoutemapSummary()
+
+	err := multierr.Combine(
+		ValidateLimits(rmap, opts.Limits),
+		startValidate(rmap, &summary),
+		ValidatePolicy(rmap, opts.Policy),
+		ValidateVocabulary(rmap, opts.Vocabulary))
+
+	if opts.CheckLabelCase {
+		err = multierr.Append(err, ValidateLabelCase(rmap))
+	}
+
+	summary.Finish()
+	return summary, err
+}
+
 func isAsciiOnly(s string) bool {
 	for _, c := range s {
 		if c > unicode.MaxASCII {
@@ -86,6 +124,16 @@ func ValidateNetmaskLen(ipnet *net.IPNet) error {
 // If the network can not be parsed, only the error instance will contain values.
 // The correctness of the network depends on the returned error having a nil value.
 func ValidateNetwork(network string) (net.IP, *net.IPNet, error) {
+	if p, ok := validPrefix(network); ok {
+		ip, ipnet := ipNetOf(p)
+		return ip, ipnet, nil
+	}
+
+	return validateCIDR(network)
+}
+
+// validateCIDR is ValidateNetwork without the fast path.
+func validateCIDR(network string) (net.IP, *net.IPNet, error) {
 	var errs []error
 
 	ip, ipnet, err := net.ParseCIDR(network)
@@ -103,6 +151,50 @@ func ValidateNetwork(network string) (net.IP, *net.IPNet, error) {
 	return ip, ipnet, multierr.Combine(errs...)
 }
 
+// validPrefix is the allocation-free fast path of ValidateNetwork for the
+// common case: it reports whether network is a valid network. When it returns
+// false the network may still be valid; only ValidateNetwork can tell.
+func validPrefix(network string) (netip.Prefix, bool) {
+	p, err := netip.ParsePrefix(network)
+	if err != nil || p.Masked() != p {
+		return p, false
+	}
+
+	switch {
+	case p.Addr().Is4():
+		return p, p.Bits() <= model.MaxNetworkBitsV4
+	case p.Addr().Is6() && !p.Addr().Is4In6():
+		return p, p.Bits() <= model.MaxNetworkBitsV6
+	default:
+		return p, false
+	}
+}
+
+// ipNetOf returns the IP and network of p as net.ParseCIDR does, with all of
+// their bytes in one allocation.
+func ipNetOf(p netip.Prefix) (net.IP, *net.IPNet) {
+	n := p.Addr().BitLen() / 8
+
+	buf := make([]byte, net.IPv6len+2*n)
+	ip := net.IP(buf[:net.IPv6len:net.IPv6len])
+	network := net.IP(buf[net.IPv6len : net.IPv6len+n : net.IPv6len+n])
+	mask := net.IPMask(buf[net.IPv6len+n:])
+
+	a := p.Addr().As16()
+	copy(ip, a[:])
+	copy(network, ip[net.IPv6len-n:])
+
+	for i, ones := 0, p.Bits(); ones > 0; i, ones = i+1, ones-8 {
+		if ones >= 8 {
+			mask[i] = 0xff
+		} else {
+			mask[i] = ^byte(0xff >> uint(ones))
+		}
+	}
+
+	return ip, &net.IPNet{IP: network, Mask: mask}
+}
+
 func ValidateNetworks(nets []string, mapIdx int, summary *model.RoutemapSummary) error {
 	var (
 		allErrs error
@@ -113,9 +205,18 @@ func ValidateNetworks(nets []string, mapIdx int, summary *model.RoutemapSummary)
 	summary.NumNetworks += len(nets)
 
 	for idx, n := range nets {
-		lg.Tracef("visiting networks at index=%d, map segment index=%d...", idx, mapIdx)
+		if lg.EnabledFor(lg.LevelTrace) {
+			lg.With(lg.F("segment", mapIdx), lg.F("index", idx)).Tracef("visiting network")
+		}
+
+		if p, ok := validPrefix(n); ok {
+			summary.SummarizePrefix(p)
+			continue
+		}
 
-		_, ipnet, err = ValidateNetwork(n)
+		// Only invalid networks, or valid ones the fast path does not accept,
+		// get here. The full checks have the final say and describe the errors.
+		_, ipnet, err = validateCIDR(n)
 		if err != nil {
 			for _, e := range multierr.Errors(err) {
 				// Rehydrate the packed errors so we can set the proper individual
@@ -129,11 +230,7 @@ func ValidateNetworks(nets []string, mapIdx int, summary *model.RoutemapSummary)
 
 		// ValidateNetwork will return a nil ipnet if the string was unparsable.
 		if ipnet != nil {
-			if _, bits := ipnet.Mask.Size(); bits == 32 {
-				summary.NumIPv4 += 1
-			} else {
-				summary.NumIPv6 += 1
-			}
+			summary.SummarizeNetwork(ipnet)
 		}
 	}
 
@@ -152,7 +249,9 @@ func ValidateLabels(labels []string, mapIdx int, summary *model.RoutemapSummary)
 	uniqueLabels := map[string]bool{}
 
 	for idx, lbl := range labels {
-		lg.Tracef("visiting labels at index=%d, map segment index=%d...", idx, mapIdx)
+		if lg.EnabledFor(lg.LevelTrace) {
+			lg.With(lg.F("segment", mapIdx), lg.F("index", idx)).Tracef("visiting label")
+		}
 
 		if len(lbl) == 0 || len(strings.TrimSpace(lbl)) == 0 {
 			multierr.AppendInto(&allErrs,
@@ -207,7 +306,10 @@ func startValidate(root *model.RoutemapRoot, summary *model.RoutemapSummary) err
 	)
 
 	for idx, m := range root.Routemap {
-		lg.Tracef("visiting map segment at index %d...", idx)
+		if lg.EnabledFor(lg.LevelTrace) {
+			lg.With(lg.F("segment", idx)).Tracef("visiting map segment")
+		}
+
 		if len(m.Networks) == 0 {
 			multierr.AppendInto(&allErrs, fmt.Errorf("map segment at index %d has no networks defined", idx))
 			continue
@@ -215,11 +317,131 @@ func startValidate(root *model.RoutemapRoot, summary *model.RoutemapSummary) err
 
 		multierr.AppendInto(&allErrs, ValidateNetworks(m.Networks, idx, summary))
 		multierr.AppendInto(&allErrs, ValidateLabels(m.Labels, idx, summary))
+		summary.SummarizeLabelNetworks(m.Labels)
 
 		if lg.EnabledFor(lg.LevelDebug) && (summary.NumNetworks-lastProgressReport) > 500000 {
 			numErrs := len(multierr.Errors(allErrs))
-			lg.Debugf("validation progress: at map segment index %d/%d; networks visited = %d, errors = %d",
-				idx, numSegments, summary.NumNetworks, numErrs)
+			lg.With(lg.F("segment", idx), lg.F("networks", summary.NumNetworks), lg.F("errors", numErrs)).
+				Debugf("validation progress: at map segment index %d/%d", idx, numSegments)
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateLimits checks the route map against the customer-specific limits.
+func ValidateLimits(root *model.RoutemapRoot, limits model.Limits) error {
+	var allErrs error
+
+	if limits.MaxSegments > 0 && len(root.Routemap) > limits.MaxSegments {
+		multierr.AppendInto(&allErrs,
+			fmt.Errorf("map has %d segments; limit is %d", len(root.Routemap), limits.MaxSegments))
+	}
+
+	if limits.MaxSizeBytes > 0 && root.SizeInBytes > limits.MaxSizeBytes {
+		multierr.AppendInto(&allErrs,
+			fmt.Errorf("map is %d bytes; limit is %d", root.SizeInBytes, limits.MaxSizeBytes))
+	}
+
+	return allErrs
+}
+
+// ValidatePolicy checks that each map segment ends with the fallback labels
+// that p requires for its first label. A nil p is not checked.
+func ValidatePolicy(root *model.RoutemapRoot, p *policy.Policy) error {
+	if p == nil {
+		return nil
+	}
+
+	var allErrs error
+
+	for idx, m := range root.Routemap {
+		if err := p.Check(m.Labels); err != nil {
+			multierr.AppendInto(&allErrs, fmt.Errorf("%v (at map segment index=%d)", err, idx))
+		}
+	}
+
+	return allErrs
+}
+
+// labelUse records where a label, exactly as written, is used.
+type labelUse struct {
+	label    string
+	first    int // Index of the first map segment using it.
+	segments int
+}
+
+// labelUses lists the distinct labels of root in order of first use.
+func labelUses(root *model.RoutemapRoot) []*labelUse {
+	var (
+		uses  []*labelUse
+		index = make(map[string]*labelUse)
+	)
+
+	for idx, m := range root.Routemap {
+		for _, lbl := range m.Labels {
+			u, ok := index[lbl]
+			if !ok {
+				u = &labelUse{label: lbl, first: idx}
+				index[lbl] = u
+				uses = append(uses, u)
+			}
+
+			u.segments++
+		}
+	}
+
+	return uses
+}
+
+// ValidateVocabulary reports each label of root that is not in v, suggesting
+// the known label it may be a typo of, and each known label written with
+// different case. A nil v is not checked.
+func ValidateVocabulary(root *model.RoutemapRoot, v *policy.Vocabulary) error {
+	if v == nil {
+		return nil
+	}
+
+	var allErrs error
+
+	for _, u := range labelUses(root) {
+		if known, ok := v.Lookup(u.label); ok {
+			if known != u.label {
+				multierr.AppendInto(&allErrs,
+					fmt.Errorf("label \"%s\" should be written \"%s\" (in %d map segments, first at index=%d)",
+						u.label, known, u.segments, u.first))
+			}
+		} else if suggestion, ok := v.Suggest(u.label); ok {
+			multierr.AppendInto(&allErrs,
+				fmt.Errorf("unknown label \"%s\"; did you mean \"%s\"? (in %d map segments, first at index=%d)",
+					u.label, suggestion, u.segments, u.first))
+		} else {
+			multierr.AppendInto(&allErrs,
+				fmt.Errorf("unknown label \"%s\" (in %d map segments, first at index=%d)",
+					u.label, u.segments, u.first))
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateLabelCase reports labels that are written with different case in
+// different map segments. The first spelling of a label is taken as the
+// right one.
+func ValidateLabelCase(root *model.RoutemapRoot) error {
+	var (
+		allErrs error
+		first   = make(map[string]*labelUse)
+	)
+
+	for _, u := range labelUses(root) {
+		key := strings.ToLower(u.label)
+		if f, ok := first[key]; !ok {
+			first[key] = u
+		} else {
+			multierr.AppendInto(&allErrs,
+				fmt.Errorf("label \"%s\" (in %d map segments, first at index=%d) differs in case from \"%s\" (first at index=%d)",
+					u.label, u.segments, u.first, f.label, f.first))
 		}
 	}
 
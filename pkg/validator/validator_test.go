@@ -19,7 +19,9 @@ import (
 	"testing"
 
 	"github.com/ns1/pulsar-routemap/pkg/model"
+	"github.com/ns1/pulsar-routemap/pkg/policy"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_validateProperCIDR(t *testing.T) {
@@ -96,3 +98,89 @@ func Test_validateLabels(t *testing.T) {
 		}
 	}
 }
+
+func Test_ValidateRoot_summary(t *testing.T) {
+	root := &model.RoutemapRoot{
+		Meta: map[string]interface{}{"version": 1},
+		Routemap: []model.Routemap{
+			{Networks: []string{"10.0.0.0/24", "10.0.1.0/26", "2001:db8::/48"}, Labels: []string{"syd", "mel"}},
+			{Networks: []string{"10.1.0.0/24", "10.0.0.0/25", "bogus"}, Labels: []string{"syd"}},
+		},
+	}
+
+	summary, err := ValidateRoot(root, Options{})
+	assert.Error(t, err)
+
+	assert.Equal(t, 6, summary.NumNetworks)
+	assert.Equal(t, 4, summary.NumIPv4)
+	assert.Equal(t, 1, summary.NumIPv6)
+	assert.Equal(t, map[int]int{24: 2, 25: 1, 26: 1}, summary.IPv4PrefixLengths)
+	assert.Equal(t, map[int]int{48: 1}, summary.IPv6PrefixLengths)
+	assert.Equal(t, "576", summary.Coverage.IPv4Addrs.String())
+	assert.Equal(t, "704", summary.Coverage.IPv4AddrsSum.String())
+	assert.Equal(t, "65536", summary.Coverage.IPv6Nets64.String())
+
+	syd := summary.Labels["syd"]
+	assert.Equal(t, 2, syd.Segments)
+	assert.Equal(t, 6, syd.Networks)
+	assert.Equal(t, "576", syd.IPv4Addrs.String())
+	assert.Equal(t, "704", syd.IPv4AddrsSum.String())
+
+	mel := summary.Labels["mel"]
+	assert.Equal(t, 1, mel.Segments)
+	assert.Equal(t, 3, mel.Networks)
+	assert.Equal(t, "320", mel.IPv4Addrs.String())
+	assert.Equal(t, "65536", mel.IPv6Nets64.String())
+}
+
+func Test_ValidateRoot_policy(t *testing.T) {
+	p, err := policy.New(policy.File{
+		Fallbacks: map[string][]string{"syd": {"apac", "global"}},
+		Default:   []string{"global"},
+	})
+	require.NoError(t, err)
+
+	root := &model.RoutemapRoot{
+		Meta: map[string]interface{}{"version": 1},
+		Routemap: []model.Routemap{
+			{Networks: []string{"10.0.0.0/24"}, Labels: []string{"syd", "mel", "apac", "global"}},
+			{Networks: []string{"10.1.0.0/24"}, Labels: []string{"syd", "global"}},
+			{Networks: []string{"10.2.0.0/24"}, Labels: []string{"hkg"}},
+		},
+	}
+
+	_, err = ValidateRoot(root, Options{})
+	assert.NoError(t, err)
+
+	_, err = ValidateRoot(root, Options{Policy: p})
+	assert.EqualError(t, err, `labels "syd,global" do not end with the fallbacks "apac,global" required for `+
+		`primary label "syd" (at map segment index=1); labels "hkg" do not end with the fallbacks "global" `+
+		`required for primary label "hkg" (at map segment index=2)`)
+}
+
+func Test_ValidateRoot_labels(t *testing.T) {
+	v, err := policy.NewVocabulary([]string{"syd", "mel", "hkg"})
+	require.NoError(t, err)
+
+	root := &model.RoutemapRoot{
+		Meta: map[string]interface{}{"version": 1},
+		Routemap: []model.Routemap{
+			{Networks: []string{"10.0.0.0/24"}, Labels: []string{"syd", "mel"}},
+			{Networks: []string{"10.1.0.0/24"}, Labels: []string{"sydd", "mel"}},
+			{Networks: []string{"10.2.0.0/24"}, Labels: []string{"SYD", "lhr"}},
+			{Networks: []string{"10.3.0.0/24"}, Labels: []string{"sydd"}},
+		},
+	}
+
+	_, err = ValidateRoot(root, Options{})
+	assert.NoError(t, err)
+
+	_, err = ValidateRoot(root, Options{Vocabulary: v})
+	assert.EqualError(t, err, `unknown label "sydd"; did you mean "syd"? (in 2 map segments, first at index=1); `+
+		`label "SYD" should be written "syd" (in 1 map segments, first at index=2); `+
+		`unknown label "lhr" (in 1 map segments, first at index=2)`)
+
+	_, err = ValidateRoot(root, Options{CheckLabelCase: true})
+	assert.EqualError(t, err,
+		`label "SYD" (in 1 map segments, first at index=2) differs in case from "syd" (first at index=0)`)
+}